@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mertenvg/blade/internal/service"
+	"github.com/mertenvg/blade/internal/service/limits"
+	"github.com/mertenvg/blade/pkg/colorterm"
+	"github.com/mertenvg/blade/pkg/redact"
+)
+
+// effectiveService is the configuration a service actually runs with, after
+// inheritance and env interpolation, as printed by `blade config`.
+type effectiveService struct {
+	Name        string             `yaml:"name" json:"name"`
+	Type        string             `yaml:"type,omitempty" json:"type,omitempty"`
+	Abstract    bool               `yaml:"abstract,omitempty" json:"abstract,omitempty"`
+	ReplicaOf   string             `yaml:"replicaOf,omitempty" json:"replicaOf,omitempty"`
+	Source      string             `yaml:"source,omitempty" json:"source,omitempty"`
+	Profiles    []string           `yaml:"profiles,omitempty" json:"profiles,omitempty"`
+	From        []string           `yaml:"from,omitempty" json:"from,omitempty"`
+	Tags        []string           `yaml:"tags,omitempty" json:"tags,omitempty"`
+	DependsOn   []string           `yaml:"dependsOn,omitempty" json:"dependsOn,omitempty"`
+	Dir         string             `yaml:"dir" json:"dir"`
+	Once        string             `yaml:"once,omitempty" json:"once,omitempty"`
+	Before      string             `yaml:"before,omitempty" json:"before,omitempty"`
+	Run         string             `yaml:"run" json:"run"`
+	InheritEnv  bool               `yaml:"inheritEnv" json:"inheritEnv"`
+	EnvFile     []string           `yaml:"envFile,omitempty" json:"envFile,omitempty"`
+	Env         []effectiveEnv     `yaml:"env,omitempty" json:"env,omitempty"`
+	Ports       []service.Port     `yaml:"ports,omitempty" json:"ports,omitempty"`
+	Sockets     []service.Socket   `yaml:"sockets,omitempty" json:"sockets,omitempty"`
+	Watch       *effectiveWatch    `yaml:"watch,omitempty" json:"watch,omitempty"`
+	Output      effectiveOutput    `yaml:"output" json:"output"`
+	Sleep       int                `yaml:"sleep,omitempty" json:"sleep,omitempty"`
+	DNR         bool               `yaml:"dnr,omitempty" json:"dnr,omitempty"`
+	Restart     string             `yaml:"restartStrategy,omitempty" json:"restartStrategy,omitempty"`
+	Readiness   *service.Readiness `yaml:"readiness,omitempty" json:"readiness,omitempty"`
+	Lazy        bool               `yaml:"lazy,omitempty" json:"lazy,omitempty"`
+	IdleTimeout int                `yaml:"idleTimeout,omitempty" json:"idleTimeout,omitempty"`
+	Skip        bool               `yaml:"skip,omitempty" json:"skip,omitempty"`
+	TTY         bool               `yaml:"tty,omitempty" json:"tty,omitempty"`
+	User        string             `yaml:"user,omitempty" json:"user,omitempty"`
+	Group       string             `yaml:"group,omitempty" json:"group,omitempty"`
+	Umask       string             `yaml:"umask,omitempty" json:"umask,omitempty"`
+	Nice        int                `yaml:"nice,omitempty" json:"nice,omitempty"`
+	IONice      string             `yaml:"ionice,omitempty" json:"ionice,omitempty"`
+	Limits      *limits.L          `yaml:"limits,omitempty" json:"limits,omitempty"`
+}
+
+type effectiveEnv struct {
+	Name   string `yaml:"name" json:"name"`
+	Value  string `yaml:"value" json:"value"`
+	Origin string `yaml:"-" json:"origin,omitempty"`
+}
+
+type effectiveWatch struct {
+	Paths  []string `yaml:"paths" json:"paths"`
+	Ignore []string `yaml:"ignore,omitempty" json:"ignore,omitempty"`
+}
+
+type effectiveOutput struct {
+	Stdout string `yaml:"stdout" json:"stdout"`
+	Stderr string `yaml:"stderr" json:"stderr"`
+	Stdin  string `yaml:"stdin" json:"stdin"`
+}
+
+// printConfig implements `blade config [--format=yaml|json] [--show-origin]
+// [name-or-tag ...]`. conf must already be fully resolved, with the active
+// profiles applied.
+func printConfig(args []string, conf []*service.S, services map[string]*service.S, groups map[string][]*service.S, profiles []string) {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	format := fs.String("format", "yaml", "output format, yaml or json")
+	showOrigin := fs.Bool("show-origin", false, "annotate services and env values with where they came from")
+	tokens := parseInterspersed(fs, args)
+
+	selected := conf
+	if len(tokens) > 0 {
+		var err error
+		if selected, err = selectServices(tokens, services, groups, true); err != nil {
+			colorterm.Error(err)
+			os.Exit(1)
+		}
+	}
+
+	out := make([]effectiveService, 0, len(selected))
+	for _, s := range selected {
+		out = append(out, effective(s, services, *showOrigin))
+	}
+
+	// secrets interpolated into other values are masked on the way out
+	w := redact.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			colorterm.Error("Couldn't encode configuration:", err)
+			os.Exit(1)
+		}
+	case "yaml":
+		var list yaml.Node
+		if err := list.Encode(out); err != nil {
+			colorterm.Error("Couldn't encode configuration:", err)
+			os.Exit(1)
+		}
+		if *showOrigin {
+			annotateOrigins(&list, out)
+		}
+		if len(profiles) > 0 {
+			list.HeadComment = "active profiles: " + strings.Join(profiles, ", ")
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(&list); err != nil {
+			colorterm.Error("Couldn't encode configuration:", err)
+			os.Exit(1)
+		}
+	default:
+		colorterm.Errorf("unknown format '%s', expected yaml or json", *format)
+		os.Exit(1)
+	}
+}
+
+// effective flattens s into what it will run with. Env entries that were
+// overridden further down the from: chain are dropped.
+func effective(s *service.S, services map[string]*service.S, showOrigin bool) effectiveService {
+	e := effectiveService{
+		Name:       s.Name,
+		Type:       s.Type,
+		Abstract:   s.Abstract,
+		ReplicaOf:  s.ReplicaOf,
+		Profiles:   s.Profiles,
+		From:       fromChain(s, services),
+		Tags:       s.Tags,
+		DependsOn:  s.DependsOn,
+		Dir:        s.Dir,
+		Once:       s.Once,
+		Before:     s.Before,
+		Run:        s.Run,
+		InheritEnv: s.InheritEnv,
+		EnvFile:    s.EnvFile,
+		Ports:      s.Ports,
+		Sockets:    s.Sockets,
+		Output: effectiveOutput{
+			Stdout: outputTarget(s.Output.Stdout, s.Name),
+			Stderr: outputTarget(s.Output.Stderr, s.Name),
+			Stdin:  s.Output.StdinMode(),
+		},
+		Sleep:       s.Sleep,
+		DNR:         s.DNR,
+		Restart:     s.RestartStrategy,
+		Readiness:   s.Readiness,
+		Lazy:        s.Lazy,
+		IdleTimeout: s.IdleTimeout,
+		Skip:        s.Skip,
+		TTY:         s.TTY,
+		User:        s.User,
+		Group:       s.Group,
+		Umask:       s.Umask,
+		Nice:        s.Nice,
+		IONice:      s.IONice,
+		Limits:      s.Limits,
+	}
+	if showOrigin {
+		e.Source = s.Source.String()
+	}
+	if e.Dir == "" {
+		e.Dir = "."
+	}
+	if abs, err := filepath.Abs(e.Dir); err == nil {
+		e.Dir = abs
+	}
+
+	index := make(map[string]int)
+	for _, env := range s.Env {
+		v := effectiveEnv{Name: env.Name}
+		if env.IsSecret() {
+			v.Value = redact.Mask
+		} else if env.Value != nil {
+			v.Value = *env.Value
+		}
+		if showOrigin {
+			v.Origin = env.Origin
+		}
+		if i, ok := index[env.Name]; ok {
+			e.Env[i] = v
+			continue
+		}
+		index[env.Name] = len(e.Env)
+		e.Env = append(e.Env, v)
+	}
+
+	if s.Watch != nil && s.Watch.FS != nil {
+		w := &effectiveWatch{Ignore: s.Watch.FS.Ignore}
+		if s.Watch.FS.Path != nil {
+			w.Paths = append(w.Paths, *s.Watch.FS.Path)
+		}
+		w.Paths = append(w.Paths, s.Watch.FS.Paths...)
+		e.Watch = w
+	}
+	return e
+}
+
+// fromChain lists every service s inherits from, directly or not, in order
+// of precedence: the last parent in from: first, followed by its own parents.
+func fromChain(s *service.S, services map[string]*service.S) []string {
+	var chain []string
+	seen := map[string]bool{s.Name: true}
+	var walk func(s *service.S)
+	walk = func(s *service.S) {
+		for i := len(s.From) - 1; i >= 0; i-- {
+			from := s.From[i]
+			if seen[from] {
+				continue
+			}
+			seen[from] = true
+			chain = append(chain, from)
+			if parent, ok := services[from]; ok {
+				walk(parent)
+			}
+		}
+	}
+	walk(s)
+	return chain
+}
+
+func outputTarget(output, name string) string {
+	switch {
+	case output == "":
+		return "discard"
+	case strings.HasPrefix(output, "file:"):
+		return strings.ReplaceAll(output, "{service-name}", name)
+	}
+	return output
+}
+
+// annotateOrigins adds the origin of every env value to the encoded services
+// as a line comment.
+func annotateOrigins(list *yaml.Node, out []effectiveService) {
+	for i, svc := range list.Content {
+		env := mappingValue(svc, "env")
+		if env == nil {
+			continue
+		}
+		for j, item := range env.Content {
+			if value := mappingValue(item, "value"); value != nil {
+				value.LineComment = out[i].Env[j].Origin
+			}
+		}
+	}
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mertenvg/blade/internal/service"
+	"github.com/mertenvg/blade/pkg/redact"
+)
+
+func strPtr(s string) *string { return &s }
+
+func TestEffective_EnvOriginsAndChain(t *testing.T) {
+	t.Setenv("BLADE_TEST_HOST", "example.com")
+
+	base := &service.S{Name: "base", Env: []service.EnvValue{
+		{Name: "PORT", Value: strPtr("8080")},
+		{Name: "LEVEL", Value: strPtr("info")},
+	}}
+	api := &service.S{Name: "api", From: service.Parents{"base"}, Run: "./api", Output: service.Output{Stdout: "file:logs/{service-name}.log"}, Env: []service.EnvValue{
+		{Name: "LEVEL", Value: strPtr("debug")},
+		{Name: "BLADE_TEST_HOST"},
+		{Name: "URL", Value: strPtr("http://{$BLADE_TEST_HOST}:{$PORT}")},
+	}}
+	services := map[string]*service.S{"base": base, "api": api}
+	for _, s := range []*service.S{base, api} {
+		for i := range s.Env {
+			s.Env[i].Origin = s.Name
+		}
+	}
+	if err := InheritRecursive(api, services, make(map[*service.S]bool), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResolveEnv(api); err != nil {
+		t.Fatal(err)
+	}
+
+	got := effective(api, services, true)
+	if !reflect.DeepEqual(got.From, []string{"base"}) {
+		t.Errorf("unexpected from chain %v", got.From)
+	}
+	if got.Output.Stdout != "file:logs/api.log" || got.Output.Stderr != "discard" {
+		t.Errorf("unexpected output %+v", got.Output)
+	}
+	want := []effectiveEnv{
+		{Name: "PORT", Value: "8080", Origin: "base"},
+		{Name: "LEVEL", Value: "debug", Origin: "api"},
+		{Name: "BLADE_TEST_HOST", Value: "example.com", Origin: "api; taken from environment"},
+		{Name: "URL", Value: "http://example.com:8080", Origin: "api; interpolated from 'http://{$BLADE_TEST_HOST}:{$PORT}'"},
+	}
+	if !reflect.DeepEqual(got.Env, want) {
+		t.Errorf("unexpected env:\n got %+v\nwant %+v", got.Env, want)
+	}
+
+	if hidden := effective(api, services, false); hidden.Env[0].Origin != "" {
+		t.Errorf("expected no origin without --show-origin, got %q", hidden.Env[0].Origin)
+	}
+}
+
+func TestEffective_MasksSecrets(t *testing.T) {
+	s := &service.S{Name: "db", Run: "./db --password ${DB_PASSWORD}", Env: []service.EnvValue{
+		{Name: "DB_PASSWORD", Value: strPtr("hunter2"), Secret: true},
+		{Name: "DB_TOKEN", ValueFrom: &service.ValueFrom{Command: "pass show db"}},
+	}}
+	if err := ResolveEnv(s); err != nil {
+		t.Fatal(err)
+	}
+	got := effective(s, map[string]*service.S{"db": s}, true)
+	for _, env := range got.Env {
+		if env.Value != redact.Mask {
+			t.Errorf("expected %s to be masked, got %q", env.Name, env.Value)
+		}
+	}
+	if got.Env[1].Origin != "; read from command 'pass show db'" {
+		t.Errorf("unexpected origin %q", got.Env[1].Origin)
+	}
+	if run := redact.String(got.Run); run != "./db --password ****" {
+		t.Errorf("expected the interpolated secret to be masked, got %q", run)
+	}
+
+	s = &service.S{Name: "api", Run: "./api ${DB_TOKEN}", Env: []service.EnvValue{
+		{Name: "DB_TOKEN", ValueFrom: &service.ValueFrom{File: "token"}},
+	}}
+	if err := ResolveEnv(s); err == nil {
+		t.Error("expected referencing a valueFrom variable to fail")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includeEntry is an item of a top-level include: list. It is either a glob
+// pattern, or a mapping with the pattern and a prefix for the names of the
+// services it pulls in.
+type includeEntry struct {
+	Path   string `yaml:"path"`
+	Prefix string `yaml:"prefix"`
+}
+
+func (e *includeEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&e.Path)
+	}
+	type plain includeEntry
+	return node.Decode((*plain)(e))
+}
+
+// include loads the files matched by each entry of the include: list of f.
+// Patterns are relative to the directory of f, and services in the included
+// files resolve their relative paths against their own directory.
+func (p *configParser) include(f configFile, list *yaml.Node, stack []string) []configItem {
+	if list.Kind != yaml.SequenceNode {
+		p.errs = append(p.errs, configError{f.Path, list.Line, "include: expected a list of paths"})
+		return nil
+	}
+	self, err := filepath.Abs(f.Path)
+	if err != nil {
+		p.errs = append(p.errs, configError{f.Path, list.Line, err.Error()})
+		return nil
+	}
+	stack = append(stack, self)
+	if len(stack) > RecursionLimit {
+		p.errs = append(p.errs, configError{f.Path, list.Line, fmt.Sprintf("include: recursion limit (%v) reached", RecursionLimit)})
+		return nil
+	}
+
+	var items []configItem
+	for _, node := range list.Content {
+		var entry includeEntry
+		if err := node.Decode(&entry); err != nil {
+			p.errs = append(p.errs, yamlErrors(f.Path, err)...)
+			continue
+		}
+		if entry.Path == "" {
+			p.errs = append(p.errs, configError{f.Path, node.Line, "include: missing path"})
+			continue
+		}
+
+		pattern := entry.Path
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(self), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			p.errs = append(p.errs, configError{f.Path, node.Line, fmt.Sprintf("include: %v", err)})
+			continue
+		}
+		if len(matches) == 0 {
+			p.errs = append(p.errs, configError{f.Path, node.Line, fmt.Sprintf("include: '%s' matched no files", entry.Path)})
+			continue
+		}
+
+		var included []configItem
+		for _, match := range matches {
+			if i := slices.Index(stack, match); i >= 0 {
+				cycle := append(slices.Clone(stack[i:]), match)
+				for j := range cycle {
+					cycle[j] = relativePath(cycle[j])
+				}
+				p.errs = append(p.errs, configError{f.Path, node.Line, "include cycle: " + strings.Join(cycle, " -> ")})
+				continue
+			}
+			if p.seen[match] {
+				// already loaded, e.g. matched by another pattern
+				continue
+			}
+			p.seen[match] = true
+			data := TryFile(match)
+			if data == nil {
+				continue
+			}
+			sub := configFile{Path: relativePath(match), Data: data, Dir: relativePath(filepath.Dir(match))}
+			included = append(included, p.parse(sub, stack)...)
+		}
+		if entry.Prefix != "" {
+			prefixNames(included, entry.Prefix)
+		}
+		items = append(items, included...)
+	}
+	return items
+}
+
+// prefixNames puts prefix in front of the name of every item, and of from:,
+// dependsOn: and {$services.<name>.VAR} references between them, so that
+// included services don't collide with services of the same name elsewhere.
+// References to services outside items, and to tags, are kept, so included
+// services can still inherit from shared templates.
+func prefixNames(items []configItem, prefix string) {
+	names := make(map[string]bool, len(items))
+	for _, item := range items {
+		names[item.Name] = true
+	}
+	for _, item := range items {
+		for i, from := range item.From {
+			if names[from] {
+				item.From[i] = prefix + from
+			}
+		}
+		for i, dep := range item.DependsOn {
+			if names[dep] {
+				item.DependsOn[i] = prefix + dep
+			}
+		}
+		for i, e := range item.Env {
+			if e.Value != nil {
+				v := prefixReferences(*e.Value, prefix, names)
+				item.Env[i].Value = &v
+			}
+		}
+		for _, f := range expandedFields(item.S) {
+			*f.value = prefixReferences(*f.value, prefix, names)
+		}
+		item.Name = prefix + item.Name
+	}
+}
+
+// prefixReferences puts prefix in front of the service names in the
+// {$services.<name>.VAR} references of value that are in names.
+func prefixReferences(value, prefix string, names map[string]bool) string {
+	const ref = "services."
+	var b strings.Builder
+	for {
+		i := strings.Index(value, ref)
+		if i < 0 {
+			break
+		}
+		start := i + len(ref)
+		b.WriteString(value[:start])
+		opened := i >= 2 && (value[i-2:i] == "${" || value[i-2:i] == "{$")
+		if name, _, ok := strings.Cut(value[start:], "."); opened && ok && names[name] {
+			b.WriteString(prefix)
+		}
+		value = value[start:]
+	}
+	b.WriteString(value)
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mertenvg/blade/internal/service"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseConfig_Include(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFile(t, "blade.yaml", `
+include:
+  - services/*/blade.yaml
+  - path: teams/payments.yaml
+    prefix: payments-
+services:
+  - name: _base
+    skip: true
+    inheritEnv: true
+`)
+	writeFile(t, "services/api/blade.yaml", `
+- name: api
+  from: _base
+  run: go run .
+  watch:
+    fs:
+      path: .
+`)
+	writeFile(t, "teams/payments.yaml", `
+- name: api
+  run: ./payments
+- name: worker
+  from: api
+`)
+
+	items, errs, _ := parseConfig(LoadConfig(nil))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	got := make(map[string]configItem)
+	for _, item := range items {
+		got[item.Name] = item
+	}
+	if len(items) != 4 {
+		t.Fatalf("expected 4 services, got %d", len(items))
+	}
+
+	api := got["api"]
+	if api.S == nil || !reflect.DeepEqual(api.From, service.Parents{"_base"}) || api.Dir != filepath.Join("services", "api") || *api.Watch.FS.Path != filepath.Join("services", "api") {
+		t.Errorf("unexpected api %+v", api.S)
+	}
+	if api.Source.File != filepath.Join("services", "api", "blade.yaml") {
+		t.Errorf("unexpected source %s", api.Source)
+	}
+	if w := got["payments-worker"]; w.S == nil || !reflect.DeepEqual(w.From, service.Parents{"payments-api"}) {
+		t.Errorf("expected prefixed worker inheriting from payments-api, got %+v", w.S)
+	}
+}
+
+func TestParseConfig_IncludePrefixReferences(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFile(t, "blade.yaml", `
+include:
+  - path: teams/payments.yaml
+    prefix: pay-
+services:
+  - name: migrate
+    type: job
+    run: ./migrate
+`)
+	writeFile(t, "teams/payments.yaml", `
+- name: migrate
+  type: job
+  run: ./migrate payments
+  env:
+    - name: DSN
+      value: postgres://payments
+- name: api
+  run: ./payments --db={$services.migrate.DSN}
+  dependsOn: [migrate, setup]
+  env:
+    - name: DB
+      value: "${services.migrate.DSN}"
+    - name: ROOT
+      value: "${services.gateway.URL}"
+`)
+
+	items, errs, _ := parseConfig(LoadConfig(nil))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	var api *service.S
+	for _, item := range items {
+		if item.Name == "pay-api" {
+			api = item.S
+		}
+	}
+	if api == nil {
+		t.Fatalf("expected pay-api among %d services", len(items))
+	}
+	if !reflect.DeepEqual(api.DependsOn, []string{"pay-migrate", "setup"}) {
+		t.Errorf("expected dependsOn the migrate job of the same file, and the setup tag kept, got %v", api.DependsOn)
+	}
+	if api.Run != "./payments --db={$services.pay-migrate.DSN}" {
+		t.Errorf("expected the reference in run to name pay-migrate, got %q", api.Run)
+	}
+	if *api.Env[0].Value != "${services.pay-migrate.DSN}" || *api.Env[1].Value != "${services.gateway.URL}" {
+		t.Errorf("expected only references to services of the same file to be prefixed, got %q and %q", *api.Env[0].Value, *api.Env[1].Value)
+	}
+}
+
+func TestParseConfig_IncludeCountsFiles(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFile(t, "blade.yaml", `
+include: [teams.yaml]
+services:
+  - name: gateway
+    run: ./gateway
+`)
+	writeFile(t, "teams.yaml", "include: [teams/*.yaml]\n")
+	writeFile(t, "teams/payments.yaml", "- name: payments\n  run: ./payments\n")
+	writeFile(t, "teams/search.yaml", "- name: search\n  run: ./search\n")
+
+	items, errs, files := parseConfig(LoadConfig(nil))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	if len(items) != 3 || files != 4 {
+		t.Fatalf("expected 3 services in 4 files, got %d in %d", len(items), files)
+	}
+}
+
+func TestParseConfig_IncludeErrors(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFile(t, "blade.yaml", "inclde: []\ninclude:\n  - missing/*.yaml\n  - a.yaml\n")
+	writeFile(t, "a.yaml", "include: [blade.yaml]\n")
+
+	_, errs, _ := parseConfig(LoadConfig(nil))
+	var msgs []string
+	for _, e := range errs {
+		msgs = append(msgs, e.String())
+	}
+	joined := strings.Join(msgs, "\n")
+	for _, want := range []string{
+		"blade.yaml:1: unknown top-level key 'inclde', did you mean 'include'?",
+		"blade.yaml:3: include: 'missing/*.yaml' matched no files",
+		"a.yaml:1: include cycle: blade.yaml -> a.yaml -> blade.yaml",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected %q in:\n%s", want, joined)
+		}
+	}
+}
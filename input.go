@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mertenvg/blade/internal/control"
+	"github.com/mertenvg/blade/internal/service"
+	"github.com/mertenvg/blade/pkg/colorterm"
+)
+
+// attachHandler answers the "attach" control command by moving the focus of
+// blade's terminal input to the named service.
+func attachHandler(router *service.StdinRouter) control.HandlerFunc {
+	return func(req control.Request) (any, error) {
+		if len(req.Args) != 1 {
+			return nil, fmt.Errorf("attach expects exactly one service name")
+		}
+		if err := router.Focus(req.Args[0]); err != nil {
+			return nil, err
+		}
+		colorterm.Info("stdin attached to", req.Args[0])
+		return router.Focused(), nil
+	}
+}
+
+// stdinHandler answers the "stdin" control command by writing the second
+// argument to the stdin of the service named by the first.
+func stdinHandler(services map[string]*service.S) control.HandlerFunc {
+	return func(req control.Request) (any, error) {
+		if len(req.Args) != 2 {
+			return nil, fmt.Errorf("stdin expects a service name and data")
+		}
+		s, ok := services[req.Args[0]]
+		if !ok {
+			return nil, fmt.Errorf("couldn't find service '%s'", req.Args[0])
+		}
+		if mode := s.Output.StdinMode(); mode != service.StdinPipe && mode != service.StdinAttach {
+			return nil, fmt.Errorf("service '%s' has stdin '%s', expected pipe or attach", s.Name, mode)
+		}
+		return s.WriteStdin([]byte(req.Args[1]))
+	}
+}
+
+// attach switches which service receives the keystrokes typed into the
+// terminal running `blade run`.
+func attach(args []string) {
+	if len(args) != 1 {
+		colorterm.None("Usage: blade attach <name>")
+		os.Exit(1)
+	}
+	var focused string
+	if err := control.Call(control.SocketPath("."), control.Request{Command: "attach", Args: args}, &focused); err != nil {
+		exitControlError(err)
+	}
+	colorterm.Success("stdin attached to", focused)
+}
+
+// send writes a line of text, or everything read from its own stdin when no
+// text is given, to the stdin of a running service.
+func send(args []string) {
+	if len(args) < 1 {
+		colorterm.None("Usage: blade send <name> [text ...]")
+		colorterm.None("Or: <command> | blade send <name>")
+		os.Exit(1)
+	}
+	name := args[0]
+	call := func(data string) {
+		if err := control.Call(control.SocketPath("."), control.Request{Command: "stdin", Args: []string{name, data}}, nil); err != nil {
+			exitControlError(err)
+		}
+	}
+
+	if len(args) > 1 {
+		call(strings.Join(args[1:], " ") + "\n")
+		return
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			call(string(buf[:n]))
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			colorterm.Error("Couldn't read input:", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mertenvg/blade/pkg/colorterm"
+)
+
+// ErrNotRunning is returned by Call when no blade instance is listening on
+// the control socket.
+var ErrNotRunning = errors.New("no running blade instance found")
+
+// Request is a single command sent to a running blade instance.
+type Request struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// Response carries either the JSON encoded result of a command or an error.
+type Response struct {
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// HandlerFunc handles a request and returns a JSON serialisable result.
+type HandlerFunc func(req Request) (any, error)
+
+// SocketPath returns the control socket used for the project rooted at root.
+// BLADE_SOCKET overrides the default location.
+func SocketPath(root string) string {
+	if p := os.Getenv("BLADE_SOCKET"); p != "" {
+		return p
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("blade-%x.sock", sum[:6]))
+}
+
+// Server answers requests on a unix socket, one JSON request and response per
+// line.
+type Server struct {
+	path     string
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+}
+
+func NewServer(path string) *Server {
+	return &Server{
+		path:     path,
+		handlers: make(map[string]HandlerFunc),
+	}
+}
+
+func (s *Server) Handle(command string, h HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = h
+}
+
+// Start listens on the socket until ctx is cancelled. It refuses to take over
+// a socket that another live blade instance is still serving.
+func (s *Server) Start(ctx context.Context) error {
+	if conn, err := net.DialTimeout("unix", s.path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("control: socket '%s' is in use by another blade instance", s.path)
+	}
+	_ = os.Remove(s.path)
+
+	ln, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("control: listen: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					colorterm.Error("control:", err)
+				}
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return nil
+}
+
+// Close removes the socket file.
+func (s *Server) Close() {
+	_ = os.Remove(s.path)
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			_ = enc.Encode(Response{Error: fmt.Sprintf("malformed request: %s", err)})
+			continue
+		}
+		_ = enc.Encode(s.dispatch(req))
+	}
+}
+
+func (s *Server) dispatch(req Request) Response {
+	s.mu.RLock()
+	h, ok := s.handlers[req.Command]
+	s.mu.RUnlock()
+	if !ok {
+		return Response{Error: fmt.Sprintf("unknown command '%s'", req.Command)}
+	}
+	v, err := h(req)
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return Response{Error: fmt.Sprintf("encode response: %s", err)}
+	}
+	return Response{Data: data}
+}
+
+// Call sends req to the blade instance listening on path and decodes the
+// result into out, which may be nil.
+func Call(path string, req Request, out any) error {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return ErrNotRunning
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("control: send: %w", err)
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("control: receive: %w", err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if out == nil || len(resp.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return fmt.Errorf("control: decode: %w", err)
+	}
+	return nil
+}
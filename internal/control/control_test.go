@@ -0,0 +1,53 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestCall_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blade.sock")
+	srv := NewServer(path)
+	srv.Handle("echo", func(req Request) (any, error) {
+		return req.Args, nil
+	})
+	srv.Handle("fail", func(req Request) (any, error) {
+		return nil, errors.New("boom")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer srv.Close()
+
+	var got []string
+	if err := Call(path, Request{Command: "echo", Args: []string{"a", "b"}}, &got); err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("unexpected echo result: %v", got)
+	}
+
+	if err := Call(path, Request{Command: "fail"}, nil); err == nil || err.Error() != "boom" {
+		t.Fatalf("expected handler error to propagate, got %v", err)
+	}
+	if err := Call(path, Request{Command: "nope"}, nil); err == nil {
+		t.Fatalf("expected error for unknown command")
+	}
+
+	// a second server must not steal a live socket
+	if err := NewServer(path).Start(ctx); err == nil {
+		t.Fatalf("expected second server to refuse a socket in use")
+	}
+}
+
+func TestCall_NotRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.sock")
+	if err := Call(path, Request{Command: "status"}, nil); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("expected ErrNotRunning, got %v", err)
+	}
+}
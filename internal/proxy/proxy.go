@@ -0,0 +1,345 @@
+// Package proxy is the HTTP reverse proxy blade runs for the proxy: section
+// of the configuration. It routes requests by hostname or path prefix to the
+// services it runs, giving the browser one stable origin, and holds requests
+// while a service restarts instead of failing them.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mertenvg/blade/internal/service"
+	"github.com/mertenvg/blade/pkg/colorterm"
+)
+
+// defaultHold is how many seconds a request waits for its upstream when the
+// configuration doesn't say.
+const defaultHold = 10
+
+// retryInterval is how often a held request retries its upstream.
+const retryInterval = 100 * time.Millisecond
+
+// Config is the proxy: section of the configuration.
+type Config struct {
+	// Listen is the address the proxy listens on, e.g. "localhost:8080".
+	Listen string `yaml:"listen"`
+	// Hold is how many seconds a request waits for its upstream to come up,
+	// e.g. while the service restarts, before it fails.
+	Hold   int     `yaml:"hold"`
+	Routes []Route `yaml:"routes"`
+}
+
+// Route sends the requests for a hostname, a path prefix or both to a port of
+// a service, or to any other upstream address.
+type Route struct {
+	Host string `yaml:"host"`
+	Path string `yaml:"path"`
+	// Strip removes Path from the request path before it is forwarded.
+	Strip   bool   `yaml:"strip"`
+	Service string `yaml:"service"`
+	// Port names a port or a socket of Service. It may be left out when the
+	// service has only one.
+	Port string `yaml:"port"`
+	// Upstream is a host:port to forward to instead of a service.
+	Upstream string `yaml:"upstream"`
+}
+
+func (rt Route) String() string {
+	target := rt.Upstream
+	if rt.Service != "" {
+		target = rt.Service
+		if rt.Port != "" {
+			target += ":" + rt.Port
+		}
+	}
+	path := rt.Path
+	if rt.Host == "" && path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s%s -> %s", rt.Host, path, target)
+}
+
+// Proxy serves the routes of a Config.
+type Proxy struct {
+	listen string
+	hold   time.Duration
+	routes []*route
+	server *http.Server
+	// running holds the names of the services started by this run
+	running map[string]bool
+}
+
+type route struct {
+	Route
+	service *service.S
+
+	network string
+	address string
+	// socket is set for a socket blade holds for the service, which takes
+	// connections even while the service is down
+	socket bool
+	proxy  *httputil.ReverseProxy
+}
+
+// New checks cfg against services and resolves the address of every route.
+func New(cfg Config, services map[string]*service.S) (*Proxy, error) {
+	if cfg.Listen == "" {
+		return nil, errors.New("proxy: missing listen address")
+	}
+	if cfg.Hold < 0 {
+		return nil, errors.New("proxy: hold can't be negative")
+	}
+	if len(cfg.Routes) == 0 {
+		return nil, errors.New("proxy: needs at least one route")
+	}
+	p := &Proxy{listen: cfg.Listen, hold: time.Duration(cfg.Hold) * time.Second}
+	if cfg.Hold == 0 {
+		p.hold = defaultHold * time.Second
+	}
+	for i, r := range cfg.Routes {
+		rt, err := p.route(r, services)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: route %d: %w", i+1, err)
+		}
+		if prev := slices.IndexFunc(p.routes, func(o *route) bool { return o.Host == rt.Host && o.Path == rt.Path }); prev >= 0 {
+			return nil, fmt.Errorf("proxy: route %d: %s%s is already routed by route %d", i+1, rt.Host, rt.Path, prev+1)
+		}
+		p.routes = append(p.routes, rt)
+	}
+	return p, nil
+}
+
+func (p *Proxy) route(r Route, services map[string]*service.S) (*route, error) {
+	r.Host = strings.ToLower(r.Host)
+	switch {
+	case r.Host == "" && r.Path == "":
+		return nil, errors.New("needs a host, a path or both")
+	case r.Path != "" && !strings.HasPrefix(r.Path, "/"):
+		return nil, fmt.Errorf("path '%s' must start with /", r.Path)
+	case r.Service == "" && r.Upstream == "":
+		return nil, errors.New("needs a service or an upstream")
+	case r.Service != "" && r.Upstream != "":
+		return nil, errors.New("takes either a service or an upstream, not both")
+	case r.Upstream != "" && r.Port != "":
+		return nil, errors.New("port only applies to a service")
+	}
+	r.Path = strings.TrimSuffix(r.Path, "/")
+
+	rt := &route{Route: r, network: "tcp", address: r.Upstream}
+	if r.Service != "" {
+		s, ok := services[r.Service]
+		if !ok {
+			return nil, fmt.Errorf("unknown service '%s'", r.Service)
+		}
+		network, address, socket, err := upstream(s, r.Port)
+		if err != nil {
+			return nil, err
+		}
+		rt.service, rt.network, rt.address, rt.socket = s, network, address, socket
+	}
+
+	target := &url.URL{Scheme: "http", Host: rt.address}
+	if rt.network == "unix" {
+		// the host is only used for the request line, the dial ignores it
+		target.Host = "localhost"
+	}
+	rt.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			// the service sees the hostname the browser used, so redirects
+			// and cookies stay on the proxy
+			pr.Out.Host = pr.In.Host
+			if rt.Strip && rt.Path != "" {
+				pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.Out.URL.Path, rt.Path), "/")
+				pr.Out.URL.RawPath = ""
+			}
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return p.dial(ctx, rt)
+			},
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     30 * time.Second,
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			colorterm.Warning("proxy:", r.Host+r.URL.Path, "->", rt.target()+":", err)
+			http.Error(w, fmt.Sprintf("blade: %s: %v", rt.target(), err), http.StatusBadGateway)
+		},
+	}
+	return rt, nil
+}
+
+// upstream returns where the port or socket named port of s listens, and
+// whether it is a socket.
+func upstream(s *service.S, port string) (string, string, bool, error) {
+	if port == "" {
+		switch {
+		case len(s.Ports) == 1 && len(s.Sockets) == 0:
+			port = s.Ports[0].Name
+		case len(s.Ports) == 0 && len(s.Sockets) == 1:
+			port = s.Sockets[0].Name
+		case len(s.Ports) == 0 && len(s.Sockets) == 0:
+			return "", "", false, fmt.Errorf("service '%s' has no ports or sockets to route to", s.Name)
+		default:
+			return "", "", false, fmt.Errorf("service '%s' has several ports, pick one with port:", s.Name)
+		}
+	}
+	for _, p := range s.Ports {
+		if p.Name == port {
+			return "tcp", net.JoinHostPort("localhost", strconv.Itoa(p.Port)), false, nil
+		}
+	}
+	for _, so := range s.Sockets {
+		if so.Name != port {
+			continue
+		}
+		network, address := so.Network()
+		if network == "unix" {
+			return network, address, true, nil
+		}
+		host, p, err := net.SplitHostPort(address)
+		if err != nil {
+			return "", "", false, fmt.Errorf("socket %s: %w", so.Name, err)
+		}
+		if p == "0" {
+			return "", "", false, fmt.Errorf("socket %s has no fixed port to route to", so.Name)
+		}
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			host = "localhost"
+		}
+		return network, net.JoinHostPort(host, p), true, nil
+	}
+	return "", "", false, fmt.Errorf("service '%s' has no port or socket named '%s'", s.Name, port)
+}
+
+func (rt *route) target() string {
+	if rt.service != nil {
+		return rt.service.Name
+	}
+	return rt.address
+}
+
+// Start listens on the proxy address and serves until ctx is cancelled.
+// Routes to services that aren't in run fail straight away instead of
+// waiting for them.
+func (p *Proxy) Start(ctx context.Context, run []*service.S) error {
+	p.running = make(map[string]bool, len(run))
+	for _, s := range run {
+		p.running[s.Name] = true
+	}
+	l, err := net.Listen("tcp", p.listen)
+	if err != nil {
+		return fmt.Errorf("proxy: %w", err)
+	}
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: 10 * time.Second}
+	go p.server.Serve(l)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		p.server.Shutdown(shutdownCtx)
+	}()
+
+	colorterm.Info("proxy listening on", l.Addr().String())
+	for _, rt := range p.routes {
+		colorterm.Info("proxy:", rt.Route.String())
+	}
+	return nil
+}
+
+// ServeHTTP forwards r along the most specific route matching it.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt := p.match(r)
+	if rt == nil {
+		routes := make([]string, len(p.routes))
+		for i, rt := range p.routes {
+			routes[i] = rt.Route.String()
+		}
+		http.Error(w, fmt.Sprintf("blade: no route for %s%s\n\nroutes:\n  %s", r.Host, r.URL.Path, strings.Join(routes, "\n  ")), http.StatusNotFound)
+		return
+	}
+	if rt.service != nil && !p.running[rt.service.Name] {
+		http.Error(w, fmt.Sprintf("blade: service '%s' isn't part of this run", rt.service.Name), http.StatusBadGateway)
+		return
+	}
+	rt.proxy.ServeHTTP(w, r)
+}
+
+// match returns the route for r. A route for the hostname wins over one for
+// any host, and a longer path over a shorter one.
+func (p *Proxy) match(r *http.Request) *route {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var best *route
+	for _, rt := range p.routes {
+		if rt.Host != "" && rt.Host != host {
+			continue
+		}
+		if rt.Path != "" && r.URL.Path != rt.Path && !strings.HasPrefix(r.URL.Path, rt.Path+"/") {
+			continue
+		}
+		if best == nil || moreSpecific(rt, best) {
+			best = rt
+		}
+	}
+	return best
+}
+
+func moreSpecific(a, b *route) bool {
+	if (a.Host != "") != (b.Host != "") {
+		return a.Host != ""
+	}
+	return len(a.Path) > len(b.Path)
+}
+
+// dial connects to the upstream of rt. While the service is down, or not
+// accepting connections yet, it keeps trying until the hold time is up, so
+// requests made during a restart are answered once the service is back. A
+// socket held by blade is dialed straight away, the connection waits in its
+// backlog, and starts the service if it is lazy.
+func (p *Proxy) dial(ctx context.Context, rt *route) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.hold)
+	defer cancel()
+
+	var d net.Dialer
+	last := fmt.Errorf("%s is not running", rt.target())
+	for {
+		if rt.socket || active(rt.service) {
+			conn, err := d.DialContext(ctx, rt.network, rt.address)
+			if err == nil {
+				return conn, nil
+			}
+			last = err
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("not ready after %s: %w", p.hold, last)
+			}
+			return nil, ctx.Err()
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// active reports whether s has a running process. Upstreams that aren't
+// services are always tried.
+func active(s *service.S) bool {
+	if s == nil {
+		return true
+	}
+	active, _, _ := s.Status()
+	return active
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mertenvg/blade/internal/service"
+)
+
+// echo returns an upstream answering with its name and the path it got.
+func echo(t *testing.T, name string) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", name, r.Host, r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+func get(t *testing.T, p *Proxy, host, path string) (int, string) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "http://"+host+path, nil)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	body, _ := io.ReadAll(w.Result().Body)
+	return w.Code, strings.TrimSpace(string(body))
+}
+
+func TestProxy_Routes(t *testing.T) {
+	api, web, docs := echo(t, "api"), echo(t, "web"), echo(t, "docs")
+	p, err := New(Config{Listen: ":0", Routes: []Route{
+		{Host: "api.localhost", Upstream: api},
+		{Host: "Web.localhost", Upstream: web},
+		{Host: "web.localhost", Path: "/docs/", Strip: true, Upstream: docs},
+		{Path: "/api", Upstream: api},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		host, path string
+		code       int
+		body       string
+	}{
+		{"api.localhost:8080", "/users", 200, "api api.localhost:8080 /users"},
+		{"web.localhost", "/", 200, "web web.localhost /"},
+		{"web.localhost", "/docs/intro", 200, "docs web.localhost /intro"},
+		{"web.localhost", "/docs", 200, "docs web.localhost /"},
+		{"web.localhost", "/docsx", 200, "web web.localhost /docsx"},
+		{"localhost", "/api/users", 200, "api localhost /api/users"},
+		{"localhost", "/", 404, ""},
+	} {
+		code, body := get(t, p, tc.host, tc.path)
+		if code != tc.code || tc.body != "" && body != tc.body {
+			t.Errorf("%s%s: expected %d %q, got %d %q", tc.host, tc.path, tc.code, tc.body, code, body)
+		}
+	}
+}
+
+func TestProxy_HoldsUntilUpstreamIsUp(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	p, err := New(Config{Listen: ":0", Hold: 5, Routes: []Route{{Path: "/", Upstream: addr}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the upstream comes up while the request is waiting, as after a restart
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "back") })}
+		t.Cleanup(func() { srv.Close() })
+		srv.Serve(l)
+	}()
+	if code, body := get(t, p, "localhost", "/"); code != 200 || body != "back" {
+		t.Fatalf("expected the request to be held until the upstream was up, got %d %q", code, body)
+	}
+}
+
+func TestProxy_FailsAfterHold(t *testing.T) {
+	api := &service.S{Name: "api", Ports: []service.Port{{Name: "http", Port: 1}}}
+	p, err := New(Config{Listen: ":0", Hold: 1, Routes: []Route{{Host: "api.localhost", Service: "api"}}}, map[string]*service.S{"api": api})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer p.server.Close()
+	if code, body := get(t, p, "api.localhost", "/"); code != http.StatusBadGateway || body != "blade: service 'api' isn't part of this run" {
+		t.Errorf("unexpected response %d %q", code, body)
+	}
+
+	p.running["api"] = true
+	start := time.Now()
+	code, body := get(t, p, "api.localhost", "/")
+	if code != http.StatusBadGateway || !strings.HasPrefix(body, "blade: api: not ready after 1s: api is not running") {
+		t.Errorf("unexpected response %d %q", code, body)
+	}
+	if time.Since(start) < time.Second {
+		t.Errorf("expected the request to be held for a second, failed after %s", time.Since(start))
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	services := map[string]*service.S{
+		"api":    {Name: "api", Ports: []service.Port{{Name: "http", Port: 8080}, {Name: "grpc", Port: 9090}}},
+		"web":    {Name: "web", Sockets: []service.Socket{{Name: "http", Listen: "127.0.0.1:0"}}},
+		"worker": {Name: "worker"},
+	}
+	for _, tc := range []struct {
+		route Route
+		err   string
+	}{
+		{Route{Service: "api"}, "proxy: route 1: needs a host, a path or both"},
+		{Route{Path: "api", Service: "api"}, "proxy: route 1: path 'api' must start with /"},
+		{Route{Path: "/api"}, "proxy: route 1: needs a service or an upstream"},
+		{Route{Path: "/api", Service: "api", Upstream: ":80"}, "proxy: route 1: takes either a service or an upstream, not both"},
+		{Route{Path: "/api", Service: "api"}, "proxy: route 1: service 'api' has several ports, pick one with port:"},
+		{Route{Path: "/api", Service: "api", Port: "admin"}, "proxy: route 1: service 'api' has no port or socket named 'admin'"},
+		{Route{Path: "/", Service: "web"}, "proxy: route 1: socket http has no fixed port to route to"},
+		{Route{Path: "/", Service: "worker"}, "proxy: route 1: service 'worker' has no ports or sockets to route to"},
+	} {
+		_, err := New(Config{Listen: ":0", Routes: []Route{tc.route}}, services)
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%+v: expected %q, got %v", tc.route, tc.err, err)
+		}
+	}
+
+	_, err := New(Config{Listen: ":0", Routes: []Route{
+		{Host: "api.localhost", Service: "api", Port: "http"},
+		{Host: "API.localhost", Service: "api", Port: "grpc"},
+	}}, services)
+	if err == nil || err.Error() != "proxy: route 2: api.localhost is already routed by route 1" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+// Package dotenv reads variables from .env files.
+//
+// Supported syntax: KEY=value lines, an optional `export ` prefix, blank
+// lines and # comments, single quoted values taken literally, double quoted
+// values with \n, \t, \", \\ and \$ escapes, quoted values spanning several
+// lines, and ${VAR} or $VAR references in unquoted and double quoted values.
+// A reference to a variable that isn't set is an error.
+package dotenv
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Var is a variable read from a dotenv file.
+type Var struct {
+	Name  string
+	Value string
+}
+
+// LookupFunc resolves a reference to a variable that isn't defined earlier in
+// the file.
+type LookupFunc func(name string) (string, bool)
+
+// Load reads the dotenv file at path.
+func Load(path string, lookup LookupFunc) ([]Var, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	vars, err := Parse(f, lookup)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return vars, nil
+}
+
+// Parse reads variables from r, in the order they are defined. References are
+// resolved against the variables defined before them, then lookup.
+func Parse(r io.Reader, lookup LookupFunc) ([]Var, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: strings.ReplaceAll(string(data), "\r\n", "\n"), line: 1, lookup: lookup, defined: make(map[string]string)}
+	return p.parse()
+}
+
+type parser struct {
+	src     string
+	pos     int
+	line    int
+	lookup  LookupFunc
+	defined map[string]string
+	vars    []Var
+}
+
+func (p *parser) errorf(format string, a ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, a...))
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.src) && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) skipLine() {
+	for p.pos < len(p.src) && p.peek() != '\n' {
+		p.pos++
+	}
+}
+
+func (p *parser) parse() ([]Var, error) {
+	for {
+		for p.pos < len(p.src) && strings.IndexByte(" \t\n", p.peek()) >= 0 {
+			p.next()
+		}
+		if p.pos >= len(p.src) {
+			return p.vars, nil
+		}
+		if p.peek() == '#' {
+			p.skipLine()
+			continue
+		}
+
+		name := p.name()
+		if name == "export" && (p.peek() == ' ' || p.peek() == '\t') {
+			p.skipSpaces()
+			name = p.name()
+		}
+		if name == "" {
+			return nil, p.errorf("expected a variable name")
+		}
+		p.skipSpaces()
+		if p.peek() != '=' {
+			return nil, p.errorf("expected '=' after %s", name)
+		}
+		p.pos++
+		p.skipSpaces()
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		p.defined[name] = value
+		p.vars = append(p.vars, Var{Name: name, Value: value})
+	}
+}
+
+func (p *parser) name() string {
+	start := p.pos
+	for p.pos < len(p.src) && isNameChar(p.peek(), p.pos == start) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9' || c == '.' || c == '-':
+		return !first
+	}
+	return false
+}
+
+func (p *parser) value() (string, error) {
+	var value string
+	switch p.peek() {
+	case '\'':
+		p.pos++
+		end := strings.IndexByte(p.src[p.pos:], '\'')
+		if end < 0 {
+			return "", p.errorf("unterminated single quoted value")
+		}
+		value = p.src[p.pos : p.pos+end]
+		p.line += strings.Count(value, "\n")
+		p.pos += end + 1
+	case '"':
+		p.pos++
+		var b strings.Builder
+		for {
+			if p.pos >= len(p.src) {
+				return "", p.errorf("unterminated double quoted value")
+			}
+			c := p.next()
+			switch c {
+			case '"':
+				value = b.String()
+			case '\\':
+				if p.pos >= len(p.src) {
+					continue
+				}
+				switch e := p.next(); e {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				case '"', '\\', '$':
+					b.WriteByte(e)
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(e)
+				}
+				continue
+			case '$':
+				v, err := p.reference()
+				if err != nil {
+					return "", err
+				}
+				b.WriteString(v)
+				continue
+			default:
+				b.WriteByte(c)
+				continue
+			}
+			break
+		}
+	default:
+		var b strings.Builder
+		for p.pos < len(p.src) && p.peek() != '\n' {
+			if p.peek() == '#' && p.pos > 0 && (p.src[p.pos-1] == ' ' || p.src[p.pos-1] == '\t') {
+				break
+			}
+			c := p.next()
+			if c == '$' {
+				v, err := p.reference()
+				if err != nil {
+					return "", err
+				}
+				b.WriteString(v)
+				continue
+			}
+			b.WriteByte(c)
+		}
+		return strings.TrimRight(b.String(), " \t"), nil
+	}
+
+	// only a comment may follow a quoted value
+	p.skipSpaces()
+	switch p.peek() {
+	case '#':
+		p.skipLine()
+	case '\n', 0:
+	default:
+		return "", p.errorf("unexpected %q after quoted value", p.peek())
+	}
+	return value, nil
+}
+
+// reference reads a variable reference after a '$' and returns its value. A
+// '$' that doesn't start a reference is kept as is. Unbraced names end at the
+// first character that can't be part of a shell variable name, so $HOST-a.b
+// is HOST followed by "-a.b".
+func (p *parser) reference() (string, error) {
+	if p.peek() == '{' {
+		end := strings.IndexAny(p.src[p.pos:], "}\n")
+		if end < 0 || p.src[p.pos+end] != '}' {
+			return "$", nil
+		}
+		name := p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		return p.resolve(name)
+	}
+	name := p.referenceName()
+	if name == "" {
+		return "$", nil
+	}
+	return p.resolve(name)
+}
+
+// referenceName reads a name of the form [A-Za-z_][A-Za-z0-9_]*.
+func (p *parser) referenceName() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.peek()
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || p.pos > start && c >= '0' && c <= '9' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *parser) resolve(name string) (string, error) {
+	if v, ok := p.defined[name]; ok {
+		return v, nil
+	}
+	if p.lookup != nil {
+		if v, ok := p.lookup(name); ok {
+			return v, nil
+		}
+	}
+	return "", p.errorf("'%s' is not set", name)
+}
@@ -0,0 +1,70 @@
+package dotenv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	src := `# database
+export DB_HOST=localhost
+DB_PORT = 5432 # default port
+DB_URL="postgres://${DB_HOST}:$DB_PORT/app"
+GREETING='hello $USER'
+CERT="line one
+line two\tend"
+KEY='a
+b'
+EMPTY=
+PRICE="$5 and \$x"
+HASH=a#b
+HOME_DIR=${HOME}/app
+URL=$DB_HOST-primary.local
+FILE="$DB_HOST.conf"
+`
+	lookup := func(name string) (string, bool) {
+		if name == "HOME" {
+			return "/home/me", true
+		}
+		return "", false
+	}
+	got, err := Parse(strings.NewReader(src), lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Var{
+		{"DB_HOST", "localhost"},
+		{"DB_PORT", "5432"},
+		{"DB_URL", "postgres://localhost:5432/app"},
+		{"GREETING", "hello $USER"},
+		{"CERT", "line one\nline two\tend"},
+		{"KEY", "a\nb"},
+		{"EMPTY", ""},
+		{"PRICE", "$5 and $x"},
+		{"HASH", "a#b"},
+		{"HOME_DIR", "/home/me/app"},
+		{"URL", "localhost-primary.local"},
+		{"FILE", "localhost.conf"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected vars:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := map[string]string{
+		"A=1\nB\n":         "line 2: expected '=' after B",
+		"A=\"open\nstill":  "line 2: unterminated double quoted value",
+		"A='x' trailing\n": "line 1: unexpected 't' after quoted value",
+		"=1\n":             "line 1: expected a variable name",
+		"A=1\nB=$UNSET\n":  "line 2: 'UNSET' is not set",
+		"A=\"${UNSET}\"\n": "line 1: 'UNSET' is not set",
+	}
+	for src, want := range cases {
+		_, err := Parse(strings.NewReader(src), nil)
+		if err == nil || err.Error() != want {
+			t.Errorf("Parse(%q) error = %v, want %q", src, err, want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/mertenvg/blade/pkg/colorterm"
+)
+
+// TypeService is a long-running service, restarted whenever it exits.
+// TypeJob runs once to completion, e.g. a migration, a seeder or a code
+// generator.
+const (
+	TypeService = "service"
+	TypeJob     = "job"
+)
+
+// stateWaiting is the state of a service waiting for the jobs it depends on,
+// stateBlocked that of one not started because one of them failed.
+const (
+	stateWaiting = "waiting for jobs"
+	stateBlocked = "not started, a job it depends on failed"
+)
+
+// JobResult is how a job ended.
+type JobResult struct {
+	Err error
+	// ExitCode is the exit status of the run command, -1 when it didn't exit
+	// by itself or didn't run at all.
+	ExitCode int
+	Duration time.Duration
+}
+
+// OK reports whether the job succeeded.
+func (r JobResult) OK() bool {
+	return r.Err == nil
+}
+
+func (r JobResult) String() string {
+	d := r.Duration.Round(time.Millisecond)
+	switch {
+	case r.OK():
+		return fmt.Sprintf("completed in %s", d)
+	case r.ExitCode > 0:
+		return fmt.Sprintf("failed with exit code %d after %s", r.ExitCode, d)
+	case r.Duration > 0:
+		return fmt.Sprintf("failed after %s: %v", d, r.Err)
+	default:
+		return fmt.Sprintf("failed: %v", r.Err)
+	}
+}
+
+// IsJob reports whether s runs once to completion instead of being kept
+// running.
+func (s *S) IsJob() bool {
+	return s.Type == TypeJob
+}
+
+func (s *S) validateType() error {
+	switch s.Type {
+	case "", TypeService:
+		return nil
+	case TypeJob:
+	default:
+		return fmt.Errorf("type: unknown type '%s', use %s or %s", s.Type, TypeService, TypeJob)
+	}
+	switch {
+	case s.Lazy:
+		return errors.New("lazy: a job runs once, it isn't started by connections")
+	case s.RestartStrategy != "":
+		return errors.New("restartStrategy: a job isn't restarted")
+	case s.Readiness != nil:
+		return errors.New("readiness: a job is done when it exits, it has no readiness probe")
+	case s.Watch != nil:
+		return errors.New("watch: a job runs once per run, it isn't restarted on changes")
+	}
+	return nil
+}
+
+// WaitFor makes s start only once every one of jobs has completed. When one
+// of them fails s isn't started at all.
+func (s *S) WaitFor(jobs ...*S) {
+	s.dependencies = append(s.dependencies, jobs...)
+}
+
+// Dependencies returns the jobs s waits for.
+func (s *S) Dependencies() []*S {
+	return s.dependencies
+}
+
+// waitDependencies blocks until every job s depends on has ended, and fails
+// with the first of them that didn't succeed.
+func (s *S) waitDependencies(ctx context.Context) error {
+	if len(s.dependencies) == 0 {
+		return nil
+	}
+	s.setState(stateWaiting)
+	colorterm.Info(s.Name, "waiting for jobs")
+	for _, job := range s.dependencies {
+		r, err := job.WaitJob(ctx)
+		if err != nil {
+			return err
+		}
+		if !r.OK() {
+			s.setState(stateBlocked)
+			return fmt.Errorf("job %s %s", job.Name, r)
+		}
+	}
+	s.setState("")
+	return nil
+}
+
+// WaitJob blocks until the job has ended and returns how, or fails when ctx
+// is done first.
+func (s *S) WaitJob(ctx context.Context) (JobResult, error) {
+	select {
+	case <-s.jobDone():
+		return s.result, nil
+	case <-ctx.Done():
+		return JobResult{}, ctx.Err()
+	}
+}
+
+func (s *S) jobDone() chan empty {
+	s.jobMu.Lock()
+	defer s.jobMu.Unlock()
+	if s.done == nil {
+		s.done = make(chan empty)
+	}
+	return s.done
+}
+
+// finish records how the job ended and releases everything waiting for it.
+func (s *S) finish(r JobResult) {
+	s.result = r
+	s.setState(r.String())
+	if r.OK() {
+		colorterm.Success(s.Name, r)
+	} else {
+		colorterm.Error(s.Name, r)
+	}
+	close(s.jobDone())
+}
+
+// runJob runs the job in the background, once, after the jobs it depends on.
+func (s *S) runJob(ctx context.Context) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.closeSockets()
+		s.finish(s.job(ctx))
+	}()
+}
+
+func (s *S) job(ctx context.Context) JobResult {
+	if err := s.waitDependencies(ctx); err != nil {
+		return JobResult{Err: err, ExitCode: -1}
+	}
+	if err := s.run(ctx, s.Before); err != nil {
+		return JobResult{Err: fmt.Errorf("'before' cmd failed: %w", err), ExitCode: -1}
+	}
+
+	startedAt := s.setStarted()
+	in, err := s.launch(ctx, s.Run, false)
+	if err != nil {
+		return JobResult{Err: err, ExitCode: -1}
+	}
+	pid := in.c.Process.Pid
+	s.setPid(pid)
+	colorterm.Success(s.Name, "running", fmt.Sprintf("(pid:%d)", pid))
+	go s.watchMemory(in.ctx, pid)
+
+	// a job is only stopped on the way out, other restarts are ignored
+	for running := true; running; {
+		select {
+		case <-in.done:
+			running = false
+		case <-ctx.Done():
+			s.stop(in)
+			running = false
+		case <-s.restartCh:
+			if s.DNR {
+				s.stop(in)
+				running = false
+			}
+		}
+	}
+	in.release()
+	s.waitForExit(ctx, pid)
+	s.setPid(0)
+
+	r := JobResult{ExitCode: -1, Duration: time.Since(startedAt)}
+	select {
+	case <-in.done:
+		r.Err = in.err
+	default:
+		return JobResult{Err: errors.New("didn't exit after SIGKILL"), ExitCode: -1, Duration: r.Duration}
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case r.Err == nil:
+		r.ExitCode = 0
+	case errors.As(r.Err, &exitErr):
+		r.ExitCode = exitErr.ExitCode()
+	}
+	if r.OK() && (ctx.Err() != nil || s.DNR) {
+		r.Err, r.ExitCode = errors.New("stopped before it completed"), -1
+	}
+	return r
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// script writes an executable shell script to a temp dir and returns its path.
+func script(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJob_RunsOnceAndReportsExitCode(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	ok := &S{Name: "migrate", Type: TypeJob, Run: script(t, "migrate", "echo run >> "+runs)}
+	failing := &S{Name: "seed", Type: TypeJob, Run: script(t, "seed", "exit 3")}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, s := range []*S{ok, failing} {
+		if err := s.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		s.Start(ctx)
+	}
+	r, err := ok.WaitJob(ctx)
+	if err != nil || !r.OK() || r.ExitCode != 0 || r.Duration <= 0 {
+		t.Errorf("expected migrate to complete, got %+v %v", r, err)
+	}
+	r, err = failing.WaitJob(ctx)
+	if err != nil || r.OK() || r.ExitCode != 3 || !strings.HasPrefix(r.String(), "failed with exit code 3 after") {
+		t.Errorf("expected seed to fail with exit code 3, got %+v %v", r, err)
+	}
+	ok.Wait()
+	failing.Wait()
+
+	// the run loop would have started it again by now
+	data, _ := os.ReadFile(runs)
+	if got := strings.Count(string(data), "run"); got != 1 {
+		t.Errorf("expected the job to run once, ran %d times", got)
+	}
+	if _, state, _ := failing.Status(); !strings.HasPrefix(state, "failed with exit code 3") {
+		t.Errorf("unexpected state %q", state)
+	}
+}
+
+func TestJob_Dependencies(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "migrated")
+	migrate := &S{Name: "migrate", Type: TypeJob, Run: script(t, "migrate", "sleep 0.3\ntouch "+marker)}
+	// fails unless migrate has run before it
+	seed := &S{Name: "seed", Type: TypeJob, Run: script(t, "seed", "test -f "+marker)}
+	seed.WaitFor(migrate)
+	broken := &S{Name: "broken", Type: TypeJob, Run: script(t, "broken", "exit 1")}
+	api := &S{Name: "api", Run: "sleep 30"}
+	api.WaitFor(broken)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, s := range []*S{api, seed, migrate, broken} {
+		s.Start(ctx)
+	}
+
+	if r, err := seed.WaitJob(ctx); err != nil || !r.OK() {
+		t.Errorf("expected seed to run after migrate, got %+v %v", r, err)
+	}
+	api.Wait()
+	if _, state, pid := api.Status(); state != stateBlocked || pid != "()" {
+		t.Errorf("expected api not to start after its job failed, got %q %s", state, pid)
+	}
+}
+
+func TestValidate_Type(t *testing.T) {
+	for _, tc := range []struct {
+		s   *S
+		err string
+	}{
+		{&S{Type: TypeJob}, ""},
+		{&S{Type: TypeService}, ""},
+		{&S{Type: "cron"}, "type: unknown type 'cron', use service or job"},
+		{&S{Type: TypeJob, Lazy: true, Sockets: []Socket{{Name: "http", Listen: ":8080"}}}, "lazy: a job runs once, it isn't started by connections"},
+		{&S{Type: TypeJob, Readiness: &Readiness{TCP: ":8080"}}, "readiness: a job is done when it exits, it has no readiness probe"},
+	} {
+		err := tc.s.Validate()
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mertenvg/blade/internal/service/proc"
+	"github.com/mertenvg/blade/pkg/colorterm"
+)
+
+// idleCheckInterval is how often the connections of a lazy service with an
+// idle timeout are counted.
+const idleCheckInterval = time.Second
+
+// stateIdle is the state of a lazy service waiting for its first connection.
+const stateIdle = "idle"
+
+func (s *S) validateLazy() error {
+	switch {
+	case s.IdleTimeout < 0:
+		return errors.New("idleTimeout can't be negative")
+	case s.IdleTimeout > 0 && !s.Lazy:
+		return errors.New("idleTimeout only applies to lazy services")
+	case s.Lazy && len(s.Sockets) == 0:
+		return errors.New("lazy: needs sockets, the service is started by the first connection to one")
+	}
+	if s.IdleTimeout > 0 {
+		for _, so := range s.Sockets {
+			if network, _ := so.Network(); network != "tcp" {
+				return fmt.Errorf("idleTimeout: can't tell when unix socket %s is idle, only tcp sockets", so.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// accepted is a connection taken from a socket of a lazy service to wake it.
+type accepted struct {
+	conn net.Conn
+	addr net.Addr
+}
+
+// waitWake blocks a lazy service until a connection arrives on one of its
+// sockets. The connections it took to notice are returned, to be handed over
+// once the service runs. A plain restart is ignored while idle, as the next
+// start picks up any change anyway.
+func (s *S) waitWake(ctx context.Context) ([]accepted, error) {
+	if err := s.openSockets(); err != nil {
+		return nil, err
+	}
+	s.setState(stateIdle)
+	defer s.setState("")
+
+	// listeners of their own, so closing them leaves the sockets open
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	for i, f := range s.socketFiles {
+		l, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("socket %s: %w", s.Sockets[i].Name, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	conns := make(chan accepted, len(listeners))
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c, err := l.Accept(); err == nil {
+				conns <- accepted{c, l.Addr()}
+			}
+		}()
+	}
+
+	var woken []accepted
+	for woken == nil && ctx.Err() == nil && !s.DNR {
+		select {
+		case c := <-conns:
+			woken = append(woken, c)
+		case <-ctx.Done():
+		case <-s.restartCh:
+		}
+	}
+	// a connection accepted by another socket in the meantime is kept too
+	for _, l := range listeners {
+		l.Close()
+	}
+	wg.Wait()
+	close(conns)
+	for c := range conns {
+		woken = append(woken, c)
+	}
+	if ctx.Err() != nil || s.DNR {
+		for _, c := range woken {
+			c.conn.Close()
+		}
+		return nil, nil
+	}
+	return woken, nil
+}
+
+// handOver passes the connections accepted while waking to the service, by
+// connecting to the socket they arrived on and copying between the two.
+func (s *S) handOver(conns []accepted) {
+	for _, c := range conns {
+		go func() {
+			defer c.conn.Close()
+			// the socket is held by blade, so this waits in its backlog
+			// until the service accepts it
+			up, err := net.Dial(c.addr.Network(), c.addr.String())
+			if err != nil {
+				colorterm.Warning(s.Name, "couldn't hand over the first connection:", err)
+				return
+			}
+			defer up.Close()
+			done := make(chan empty, 1)
+			go func() {
+				io.Copy(up, c.conn)
+				closeWrite(up)
+				done <- empty{}
+			}()
+			io.Copy(c.conn, up)
+			closeWrite(c.conn)
+			<-done
+		}()
+	}
+}
+
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
+
+// socketPorts returns the tcp ports of the sockets of the service. It is
+// called by the run loop, which owns socketAddrs.
+func (s *S) socketPorts() []int {
+	var ports []int
+	for _, addr := range s.socketAddrs {
+		if tcp, ok := addr.(*net.TCPAddr); ok {
+			ports = append(ports, tcp.Port)
+		}
+	}
+	return ports
+}
+
+// watchIdle signals idleCh once the service has had no open connections on
+// ports for IdleTimeout. It returns when ctx is cancelled or after
+// signalling.
+func (s *S) watchIdle(ctx context.Context, ports []int) {
+	if !s.Lazy || s.IdleTimeout == 0 {
+		return
+	}
+	timeout := time.Duration(s.IdleTimeout) * time.Second
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, port := range ports {
+			n, err := proc.Connections(port)
+			if err != nil {
+				colorterm.Warning(s.Name, "can't count connections, idleTimeout is ignored:", err)
+				return
+			}
+			if n > 0 {
+				last = time.Now()
+				break
+			}
+		}
+		if time.Since(last) >= timeout {
+			select {
+			case s.idleCh <- empty{}:
+			default:
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLazy_StartsOnConnectionAndStopsWhenIdle(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s, events := readyServer(t, "always")
+	s.RestartStrategy, s.Readiness = "", nil
+	s.Sockets = []Socket{{Name: "http", Listen: addr}}
+	s.Lazy, s.IdleTimeout = true, 1
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+	s.Start(ctx)
+
+	time.Sleep(300 * time.Millisecond)
+	if _, state, _ := s.Status(); state != "idle" {
+		t.Fatalf("expected the service to be idle before any connection, got %q", state)
+	}
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 10 * time.Second}
+	get := func() string {
+		resp, err := client.Get("http://" + addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// the first connection starts the service and is answered by it
+	pid := get()
+	got := waitEvents(t, events, 1)
+	if got[0] != "started_"+pid {
+		t.Fatalf("expected the request to be answered by the started service, got %q and %q", pid, got)
+	}
+
+	got = waitEvents(t, events, 2)
+	if got[1] != "stopped_"+pid {
+		t.Fatalf("expected the service to stop once idle, got %q", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, state, _ := s.Status(); state != "idle"; _, state, _ = s.Status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the service to be idle again, got %q", state)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if again := get(); again == pid || !strings.HasPrefix(waitEvents(t, events, 3)[2], "started_"+again) {
+		t.Errorf("expected a new instance to answer, got %q", again)
+	}
+}
+
+func TestValidate_Lazy(t *testing.T) {
+	for _, tc := range []struct {
+		s   *S
+		err string
+	}{
+		{&S{Lazy: true, Sockets: []Socket{{Name: "http", Listen: ":8080"}}, IdleTimeout: 60}, ""},
+		{&S{Lazy: true}, "lazy: needs sockets, the service is started by the first connection to one"},
+		{&S{IdleTimeout: 60}, "idleTimeout only applies to lazy services"},
+		{&S{Lazy: true, Sockets: []Socket{{Name: "admin", Listen: "unix:/tmp/admin.sock"}}, IdleTimeout: 60}, "idleTimeout: can't tell when unix socket admin is idle, only tcp sockets"},
+	} {
+		err := tc.s.Validate()
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
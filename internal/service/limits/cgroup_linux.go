@@ -0,0 +1,196 @@
+//go:build linux
+
+package limits
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	cgroupMount = "/sys/fs/cgroup"
+	// cpuPeriod is the cpu.max period in microseconds.
+	cpuPeriod = 100000
+)
+
+var unsafeCgroupChars = regexp.MustCompile(`[^a-zA-Z0-9_.\-]`)
+
+// base is the per-blade-process parent cgroup, created on first use and
+// removed when the last service cgroup is closed.
+var base struct {
+	sync.Mutex
+	path string
+	refs int
+}
+
+// Cgroup is a cgroup v2 sub-cgroup owned by blade for a single service.
+type Cgroup struct {
+	path string
+	dir  *os.File
+	once sync.Once
+}
+
+// NewCgroup creates <blade's cgroup>/blade-<pid>/<name> with the memory and
+// cpu limits from l. It fails when cgroup v2 isn't mounted or the controllers
+// haven't been delegated to blade's cgroup.
+func NewCgroup(name string, l *L) (*Cgroup, error) {
+	parent, err := ensureBase()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(parent, unsafeCgroupChars.ReplaceAllString(name, "_"))
+	if err := os.Mkdir(path, 0o755); err != nil && !os.IsExist(err) {
+		releaseBase()
+		return nil, fmt.Errorf("cgroup: create: %w", err)
+	}
+	cg := &Cgroup{path: path}
+	if err := cg.configure(l); err != nil {
+		cg.Close()
+		return nil, err
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		cg.Close()
+		return nil, fmt.Errorf("cgroup: open: %w", err)
+	}
+	cg.dir = dir
+	return cg, nil
+}
+
+func (cg *Cgroup) configure(l *L) error {
+	if mem := l.MemoryBytes(); mem > 0 {
+		if err := os.WriteFile(filepath.Join(cg.path, "memory.max"), []byte(strconv.FormatUint(mem, 10)), 0o644); err != nil {
+			return fmt.Errorf("cgroup: set memory.max: %w", err)
+		}
+	}
+	if l.CPU > 0 {
+		quota := int64(l.CPU * cpuPeriod)
+		if err := os.WriteFile(filepath.Join(cg.path, "cpu.max"), []byte(fmt.Sprintf("%d %d", quota, cpuPeriod)), 0o644); err != nil {
+			return fmt.Errorf("cgroup: set cpu.max: %w", err)
+		}
+	}
+	return nil
+}
+
+// Apply makes processes started with attr join the cgroup atomically at
+// clone time, so nothing they fork can escape it.
+func (cg *Cgroup) Apply(attr *syscall.SysProcAttr) {
+	if cg == nil || cg.dir == nil {
+		return
+	}
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(cg.dir.Fd())
+}
+
+// Path returns the cgroup's location in the cgroup filesystem.
+func (cg *Cgroup) Path() string {
+	if cg == nil {
+		return ""
+	}
+	return cg.path
+}
+
+// Close removes the cgroup. It must be called after every process in it has
+// exited; removal of a busy cgroup fails and is left for the system to reap.
+func (cg *Cgroup) Close() {
+	if cg == nil {
+		return
+	}
+	cg.once.Do(func() {
+		if cg.dir != nil {
+			cg.dir.Close()
+		}
+		_ = os.Remove(cg.path)
+		releaseBase()
+	})
+}
+
+func ensureBase() (string, error) {
+	base.Lock()
+	defer base.Unlock()
+	if base.path != "" {
+		base.refs++
+		return base.path, nil
+	}
+
+	own, err := ownCgroup()
+	if err != nil {
+		return "", err
+	}
+	parent := filepath.Join(cgroupMount, own)
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup: v2 hierarchy not available at %s", parent)
+	}
+	// Controllers have to be enabled on our own cgroup before children can
+	// use them. This fails unless the cgroup has been delegated to us.
+	if err := enableControllers(parent); err != nil {
+		return "", err
+	}
+	path := filepath.Join(parent, fmt.Sprintf("blade-%d", os.Getpid()))
+	if err := os.Mkdir(path, 0o755); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("cgroup: create: %w", err)
+	}
+	if err := enableControllers(path); err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+	base.path = path
+	base.refs = 1
+	return path, nil
+}
+
+func releaseBase() {
+	base.Lock()
+	defer base.Unlock()
+	base.refs--
+	if base.refs <= 0 && base.path != "" {
+		_ = os.Remove(base.path)
+		base.path = ""
+		base.refs = 0
+	}
+}
+
+func enableControllers(path string) error {
+	data, err := os.ReadFile(filepath.Join(path, "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("cgroup: read subtree_control: %w", err)
+	}
+	enabled := strings.Fields(string(data))
+	var want []string
+	for _, c := range []string{"memory", "cpu"} {
+		if !slices.Contains(enabled, c) {
+			want = append(want, "+"+c)
+		}
+	}
+	if len(want) == 0 {
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(path, "cgroup.subtree_control"), []byte(strings.Join(want, " ")), 0o644); err != nil {
+		return fmt.Errorf("cgroup: enable %s in %s: %w", strings.Join(want, " "), path, err)
+	}
+	return nil
+}
+
+// ownCgroup returns blade's cgroup v2 path from /proc/self/cgroup.
+func ownCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("cgroup: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rest, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return rest, nil
+		}
+	}
+	return "", fmt.Errorf("cgroup: no v2 entry in /proc/self/cgroup")
+}
@@ -0,0 +1,113 @@
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mertenvg/blade/pkg/coalesce"
+)
+
+// ErrUnsupported is returned when a limit can't be enforced on this platform.
+var ErrUnsupported = errors.New("resource limits are not supported on this platform")
+
+// L caps the resources available to a service. Memory and CPU are enforced
+// through a cgroup v2 sub-cgroup when blade is able to create one; NoFile and
+// NProc use setrlimit, as does Memory when no cgroup is available.
+type L struct {
+	Memory     string  `yaml:"memory,omitempty" json:"memory,omitempty"`         // hard cap, e.g. 512M or 2G
+	CPU        float64 `yaml:"cpu,omitempty" json:"cpu,omitempty"`               // cores, e.g. 0.5 or 2
+	NoFile     uint64  `yaml:"nofile,omitempty" json:"nofile,omitempty"`         // max open files
+	NProc      uint64  `yaml:"nproc,omitempty" json:"nproc,omitempty"`           // max processes for the user
+	SoftMemory string  `yaml:"softMemory,omitempty" json:"softMemory,omitempty"` // restart the service when RSS exceeds this
+}
+
+func (l *L) InheritFrom(parent *L) *L {
+	if parent == nil {
+		return l
+	}
+	if l == nil {
+		inherited := *parent
+		return &inherited
+	}
+	return &L{
+		Memory:     coalesce.String(parent.Memory, l.Memory),
+		CPU:        coalesce.Float64(parent.CPU, l.CPU),
+		NoFile:     coalesce.Uint64(parent.NoFile, l.NoFile),
+		NProc:      coalesce.Uint64(parent.NProc, l.NProc),
+		SoftMemory: coalesce.String(parent.SoftMemory, l.SoftMemory),
+	}
+}
+
+// Validate checks that every configured size parses and values are in range.
+func (l *L) Validate() error {
+	if l == nil {
+		return nil
+	}
+	if _, err := ParseBytes(l.Memory); err != nil {
+		return fmt.Errorf("limits.memory: %w", err)
+	}
+	if _, err := ParseBytes(l.SoftMemory); err != nil {
+		return fmt.Errorf("limits.softMemory: %w", err)
+	}
+	if l.CPU < 0 {
+		return fmt.Errorf("limits.cpu: must not be negative, got %v", l.CPU)
+	}
+	return nil
+}
+
+// NeedsCgroup reports whether any limit is best enforced through a cgroup.
+func (l *L) NeedsCgroup() bool {
+	return l != nil && (l.Memory != "" || l.CPU > 0)
+}
+
+// MemoryBytes returns the hard memory cap in bytes, 0 when unset.
+func (l *L) MemoryBytes() uint64 {
+	if l == nil {
+		return 0
+	}
+	n, _ := ParseBytes(l.Memory)
+	return n
+}
+
+// SoftMemoryBytes returns the RSS restart threshold in bytes, 0 when unset.
+func (l *L) SoftMemoryBytes() uint64 {
+	if l == nil {
+		return 0
+	}
+	n, _ := ParseBytes(l.SoftMemory)
+	return n
+}
+
+// ParseBytes parses sizes such as "512", "64K", "512M", "1.5G" or "2GiB".
+// Suffixes are binary multiples regardless of the optional "i" and "B". An
+// empty string is 0.
+func ParseBytes(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	upper := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	mult := uint64(1)
+	if n := len(upper); n > 0 {
+		switch upper[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			upper = upper[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return uint64(v * float64(mult)), nil
+}
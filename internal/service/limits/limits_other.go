@@ -0,0 +1,27 @@
+//go:build !linux
+
+package limits
+
+import "syscall"
+
+// Cgroup is unavailable outside Linux; NewCgroup always fails.
+type Cgroup struct{}
+
+func NewCgroup(name string, l *L) (*Cgroup, error) {
+	return nil, ErrUnsupported
+}
+
+func (cg *Cgroup) Apply(attr *syscall.SysProcAttr) {}
+
+func (cg *Cgroup) Path() string { return "" }
+
+func (cg *Cgroup) Close() {}
+
+// ApplyRlimits is unsupported outside Linux as there is no way to change the
+// limits of another running process.
+func (l *L) ApplyRlimits(pid int, memory bool) error {
+	if l == nil || (l.NoFile == 0 && l.NProc == 0 && (!memory || l.Memory == "")) {
+		return nil
+	}
+	return ErrUnsupported
+}
@@ -0,0 +1,101 @@
+package limits
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	cases := []struct {
+		in   string
+		want uint64
+		err  bool
+	}{
+		{"", 0, false},
+		{"512", 512, false},
+		{"64K", 64 << 10, false},
+		{"512M", 512 << 20, false},
+		{"512mb", 512 << 20, false},
+		{"2GiB", 2 << 30, false},
+		{"1.5G", 3 << 29, false},
+		{"1T", 1 << 40, false},
+		{"lots", 0, true},
+		{"-1M", 0, true},
+	}
+	for _, tc := range cases {
+		got, err := ParseBytes(tc.in)
+		if (err != nil) != tc.err {
+			t.Fatalf("ParseBytes(%q) error = %v, want error %v", tc.in, err, tc.err)
+		}
+		if got != tc.want {
+			t.Fatalf("ParseBytes(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestInheritFrom_ParentValuesWin(t *testing.T) {
+	parent := &L{Memory: "1G", CPU: 2}
+	child := (&L{Memory: "256M", NoFile: 1024}).InheritFrom(parent)
+	if child.Memory != "1G" || child.CPU != 2 || child.NoFile != 1024 {
+		t.Fatalf("unexpected merge: %+v", child)
+	}
+
+	inherited := (*L)(nil).InheritFrom(parent)
+	if inherited == parent {
+		t.Fatalf("nil child should receive a copy, not the parent pointer")
+	}
+	if *inherited != *parent {
+		t.Fatalf("nil child should inherit everything: %+v", inherited)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (&L{Memory: "12 parsecs"}).Validate(); err == nil {
+		t.Fatalf("expected invalid memory to fail validation")
+	}
+	if err := (&L{SoftMemory: "x"}).Validate(); err == nil {
+		t.Fatalf("expected invalid softMemory to fail validation")
+	}
+	if err := (&L{CPU: -1}).Validate(); err == nil {
+		t.Fatalf("expected negative cpu to fail validation")
+	}
+	if err := (&L{Memory: "1G", CPU: 0.5}).Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestApplyRlimits_NoFile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("prlimit is linux only")
+	}
+	c := exec.Command("sleep", "30")
+	if err := c.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer func() {
+		_ = c.Process.Kill()
+		_ = c.Wait()
+	}()
+
+	if err := (&L{NoFile: 64}).ApplyRlimits(c.Process.Pid, false); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(c.Process.Pid) + "/limits")
+	if err != nil {
+		t.Fatalf("read limits: %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "Max open files") {
+			if fields := strings.Fields(line); fields[3] != "64" || fields[4] != "64" {
+				t.Fatalf("expected nofile 64/64, got %q", line)
+			}
+			return
+		}
+	}
+	t.Fatalf("Max open files not found in %s", data)
+}
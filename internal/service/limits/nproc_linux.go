@@ -0,0 +1,6 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le && !sparc64
+
+package limits
+
+// rlimitNProc is RLIMIT_NPROC, which the syscall package doesn't export.
+const rlimitNProc = 6
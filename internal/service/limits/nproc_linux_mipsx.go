@@ -0,0 +1,6 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+
+package limits
+
+// rlimitNProc is RLIMIT_NPROC, which mips numbers after RLIMIT_RSS.
+const rlimitNProc = 8
@@ -0,0 +1,6 @@
+//go:build linux
+
+package limits
+
+// rlimitNProc is RLIMIT_NPROC, which sparc numbers after RLIMIT_NOFILE.
+const rlimitNProc = 7
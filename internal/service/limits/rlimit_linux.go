@@ -0,0 +1,44 @@
+//go:build linux
+
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// ApplyRlimits sets nofile and nproc on the running process pid, or on the
+// calling process when pid is 0, using prlimit(2). When memory is true the
+// memory cap is applied as RLIMIT_DATA, the fallback used when no cgroup is
+// available.
+func (l *L) ApplyRlimits(pid int, memory bool) error {
+	if l == nil {
+		return nil
+	}
+	var errs []error
+	set := func(name string, resource int, v uint64) {
+		if v == 0 {
+			return
+		}
+		if err := prlimit(pid, resource, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	set("nofile", syscall.RLIMIT_NOFILE, l.NoFile)
+	set("nproc", rlimitNProc, l.NProc)
+	if memory {
+		set("memory", syscall.RLIMIT_DATA, l.MemoryBytes())
+	}
+	return errors.Join(errs...)
+}
+
+func prlimit(pid, resource int, v uint64) error {
+	lim := syscall.Rlimit{Cur: v, Max: v}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&lim)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
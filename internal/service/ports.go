@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mertenvg/blade/internal/service/proc"
+	"github.com/mertenvg/blade/pkg/colorterm"
+)
+
+// Port is a TCP port the service listens on. Unless a fixed Port is given,
+// blade picks a free one when the configuration is loaded, so several copies
+// of a project can run side by side without clashing. Either way it is
+// passed to the service in Env.
+type Port struct {
+	Name string `yaml:"name" json:"name"`
+	Env  string `yaml:"env,omitempty" json:"env,omitempty"`
+	Port int    `yaml:"port,omitempty" json:"port,omitempty"`
+}
+
+// EnvName is the variable the port is passed in, <NAME>_PORT unless set.
+func (p Port) EnvName() string {
+	if p.Env != "" {
+		return p.Env
+	}
+	return strings.ToUpper(strings.ReplaceAll(p.Name, "-", "_")) + "_PORT"
+}
+
+func (p Port) String() string {
+	return fmt.Sprintf("%s:%d", p.Name, p.Port)
+}
+
+// inheritPorts merges the ports of parent into ports, keeping those of the
+// child where both declare the same name.
+func inheritPorts(ports, parent []Port) []Port {
+	merged := make([]Port, 0, len(parent)+len(ports))
+	for _, p := range parent {
+		if !hasPort(ports, p.Name) {
+			merged = append(merged, p)
+		}
+	}
+	return append(merged, ports...)
+}
+
+func hasPort(ports []Port, name string) bool {
+	for _, p := range ports {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AllocatePorts picks a free port for every declared port of services without
+// a fixed one, and adds each port to their env, after any inherited or inline
+// value so it wins. The ports stay the same for as long as blade runs, so
+// restarts keep them.
+func AllocatePorts(services []*S) error {
+	// every listener is held until all ports are picked, so no port is
+	// handed out twice
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	for _, s := range services {
+		if len(s.Ports) == 0 {
+			continue
+		}
+		// ports may still be shared with the service they were inherited from
+		ports := make([]Port, len(s.Ports))
+		for i, p := range s.Ports {
+			origin := fmt.Sprintf("port '%s'", p.Name)
+			if p.Port == 0 {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					return fmt.Errorf("%s: port %s: %w", s.Name, p.Name, err)
+				}
+				listeners = append(listeners, l)
+				p.Port = l.Addr().(*net.TCPAddr).Port
+				origin += " allocated by blade"
+			}
+			ports[i] = p
+
+			v := strconv.Itoa(p.Port)
+			s.Env = append(s.Env, EnvValue{Name: p.EnvName(), Value: &v, Origin: origin})
+		}
+		s.Ports = ports
+	}
+	return nil
+}
+
+// checkPorts makes sure nothing else listens on the ports of the service
+// before it starts. With KillConflicts the processes holding them are
+// stopped, otherwise the start fails naming them. Where listening sockets
+// can't be inspected the check is skipped.
+func (s *S) checkPorts(ctx context.Context) error {
+	for _, p := range s.Ports {
+		inUse, owners, self, err := portOwners(p.Port)
+		if err != nil || !inUse {
+			continue
+		}
+		if self && len(owners) == 0 {
+			if s.holdsSocket(p.Port) {
+				// the service's own socket, passed to it on start
+				continue
+			}
+			return fmt.Errorf("port %d (%s) is held by blade itself, as the proxy port or a socket of another service", p.Port, p.Name)
+		}
+		if len(owners) == 0 {
+			return fmt.Errorf("port %d (%s) is held by a process blade can't inspect", p.Port, p.Name)
+		}
+		if !s.KillConflicts {
+			return fmt.Errorf("port %d (%s) held by %s; stop it or use --kill-conflicts", p.Port, p.Name, describeOwners(owners))
+		}
+		if err := killOwners(ctx, p, owners); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// portOwners is proc.PortOwners without blade itself, which is never stopped
+// for a conflict. self reports whether blade holds the port.
+func portOwners(port int) (inUse bool, owners []*proc.Process, self bool, err error) {
+	inUse, all, err := proc.PortOwners(port)
+	for _, o := range all {
+		if o.PID == os.Getpid() {
+			self = true
+			continue
+		}
+		owners = append(owners, o)
+	}
+	return inUse, owners, self, err
+}
+
+// holdsSocket reports whether one of the service's open sockets is bound to
+// port.
+func (s *S) holdsSocket(port int) bool {
+	for _, addr := range s.socketAddrs {
+		if a, ok := addr.(*net.TCPAddr); ok && a.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+func describeOwners(owners []*proc.Process) string {
+	list := make([]string, len(owners))
+	for i, o := range owners {
+		list[i] = fmt.Sprintf("pid %d (%s)", o.PID, o.Command)
+	}
+	return strings.Join(list, ", ")
+}
+
+// killOwners sends SIGTERM to the processes holding p, and SIGKILL to those
+// still holding it after the grace period.
+func killOwners(ctx context.Context, p Port, owners []*proc.Process) error {
+	colorterm.Warningf("port %d (%s) held by %s, stopping it (--kill-conflicts)", p.Port, p.Name, describeOwners(owners))
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL} {
+		if len(owners) == 0 {
+			// SIGKILL can't be sent to a process that isn't known
+			return fmt.Errorf("port %d (%s) is still in use after SIGTERM, by a process blade can't inspect", p.Port, p.Name)
+		}
+		for _, o := range owners {
+			syscall.Kill(o.PID, sig)
+		}
+		deadline := time.Now().Add(gracePeriod)
+		for time.Now().Before(deadline) {
+			inUse, left, _, err := portOwners(p.Port)
+			if err != nil || !inUse {
+				return nil
+			}
+			owners = left
+			if !sleepCtx(ctx, 100*time.Millisecond) {
+				return ctx.Err()
+			}
+		}
+	}
+	if len(owners) == 0 {
+		return fmt.Errorf("port %d (%s) is still in use after SIGKILL, by a process blade can't inspect", p.Port, p.Name)
+	}
+	return fmt.Errorf("port %d (%s) still held by %s", p.Port, p.Name, describeOwners(owners))
+}
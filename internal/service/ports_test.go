@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mertenvg/blade/internal/service/proc"
+)
+
+func TestAllocatePorts(t *testing.T) {
+	parent := &S{Name: "base", Ports: []Port{{Name: "http", Env: "PORT"}, {Name: "admin"}}}
+	s := &S{Name: "api", Ports: []Port{{Name: "admin", Port: 9000}, {Name: "debug-ui"}}}
+	s.InheritFrom(parent)
+	other := &S{Name: "web", Ports: []Port{{Name: "http"}}}
+
+	if err := AllocatePorts([]*S{s, other}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Ports) != 3 || s.Ports[0].Name != "http" || s.Ports[1].Port != 9000 {
+		t.Fatalf("unexpected ports %v", s.Ports)
+	}
+	if parent.Ports[0].Port != 0 {
+		t.Error("allocating changed the ports of the parent")
+	}
+	seen := make(map[int]bool)
+	for _, p := range append(s.Ports, other.Ports...) {
+		if p.Port == 0 || seen[p.Port] {
+			t.Errorf("expected distinct allocated ports, got %v %v", s.Ports, other.Ports)
+		}
+		seen[p.Port] = true
+	}
+
+	env := make(map[string]string)
+	for _, e := range s.Env {
+		env[e.Name] = *e.Value
+	}
+	if env["PORT"] != strconv.Itoa(s.Ports[0].Port) || env["ADMIN_PORT"] != "9000" || env["DEBUG_UI_PORT"] == "" {
+		t.Errorf("unexpected env %v", env)
+	}
+
+	dup := &S{Name: "dup", Ports: []Port{{Name: "http"}, {Name: "http"}}}
+	if err := dup.Validate(); err == nil || err.Error() != "ports: 'http' is declared twice" {
+		t.Errorf("unexpected validation error %v", err)
+	}
+}
+
+func TestCheckPorts_Conflicts(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("listening sockets are read from /proc")
+	}
+	testBin, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	holder := exec.Command(testBin, "-test.run=^TestHelperPortServer$")
+	holder.Env = append(os.Environ(), "BLADE_TEST_HELPER=port-server", "BLADE_TEST_PORT="+strconv.Itoa(port))
+	if err := holder.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		holder.Wait()
+		close(exited)
+	}()
+	defer holder.Process.Kill()
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if inUse, _, _ := proc.PortOwners(port); inUse {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("helper never listened")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	s := &S{Name: "api", Ports: []Port{{Name: "http", Port: port}}}
+	err = s.checkPorts(context.Background())
+	want := fmt.Sprintf("port %d (http) held by pid %d (", port, holder.Process.Pid)
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("expected %q..., got %v", want, err)
+	}
+
+	s.KillConflicts = true
+	if err := s.checkPorts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the process holding the port to be stopped")
+	}
+}
+
+func TestCheckPorts_HeldByBlade(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("listening sockets are read from /proc")
+	}
+	// the test process stands in for blade holding the proxy port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	s := &S{Name: "api", Ports: []Port{{Name: "http", Port: port}}, KillConflicts: true}
+	want := fmt.Sprintf("port %d (http) is held by blade itself, as the proxy port or a socket of another service", port)
+	if err := s.checkPorts(context.Background()); err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+
+	// a socket of the service itself is no conflict
+	s.socketAddrs = []net.Addr{ln.Addr()}
+	if err := s.checkPorts(context.Background()); err != nil {
+		t.Fatalf("expected the service's own socket to be accepted, got %v", err)
+	}
+}
+
+func TestKillOwners_UnknownOwnerAfterSIGTERM(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("listening sockets are read from /proc")
+	}
+	// the port stays held by the test process, which is never an owner, so
+	// once the listed owner is gone nothing is left to send SIGKILL to
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+	sleeper := exec.Command("sleep", "30")
+	if err := sleeper.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go sleeper.Wait()
+	defer sleeper.Process.Kill()
+
+	err = killOwners(context.Background(), Port{Name: "http", Port: port}, []*proc.Process{{PID: sleeper.Process.Pid, Command: "sleep 30"}})
+	want := fmt.Sprintf("port %d (http) is still in use after SIGTERM, by a process blade can't inspect", port)
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+}
@@ -0,0 +1,171 @@
+package proc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned on platforms where per-process resource usage
+// cannot be read.
+var ErrUnsupported = errors.New("process statistics are not supported on this platform")
+
+// Process is a point-in-time view of a single process and its descendants.
+type Process struct {
+	PID      int           `json:"pid"`
+	PPID     int           `json:"ppid"`
+	PGID     int           `json:"pgid"`
+	Command  string        `json:"command"`
+	CPUTime  time.Duration `json:"cpuTime"`
+	RSS      uint64        `json:"rss"`
+	Threads  int           `json:"threads"`
+	FDs      int           `json:"fds"`
+	Children []*Process    `json:"children,omitempty"`
+}
+
+// Usage is the aggregated resource usage of a process tree.
+type Usage struct {
+	Processes int           `json:"processes"`
+	CPUTime   time.Duration `json:"cpuTime"`
+	RSS       uint64        `json:"rss"`
+	Threads   int           `json:"threads"`
+	FDs       int           `json:"fds"`
+}
+
+// Total sums the usage of p and all of its descendants.
+func (p *Process) Total() Usage {
+	var u Usage
+	if p == nil {
+		return u
+	}
+	p.Walk(func(p *Process, _ int) {
+		u.Processes++
+		u.CPUTime += p.CPUTime
+		u.RSS += p.RSS
+		u.Threads += p.Threads
+		u.FDs += p.FDs
+	})
+	return u
+}
+
+// Walk visits p and its descendants depth first, passing the depth of each
+// process relative to p.
+func (p *Process) Walk(fn func(p *Process, depth int)) {
+	p.walk(fn, 0)
+}
+
+func (p *Process) walk(fn func(p *Process, depth int), depth int) {
+	if p == nil {
+		return
+	}
+	fn(p, depth)
+	for _, c := range p.Children {
+		c.walk(fn, depth+1)
+	}
+}
+
+// PortOwners reports whether a TCP socket is listening on port, on any
+// address, and which processes hold it. Processes of other users may not be
+// visible, so a port can be in use without any known owner.
+func PortOwners(port int) (bool, []*Process, error) {
+	return portOwners(port)
+}
+
+// Connections returns how many TCP connections to port, on any address, are
+// established. Connections waiting to be accepted count too.
+func Connections(port int) (int, error) {
+	return connections(port)
+}
+
+// TCP socket states as shown in /proc/net/tcp.
+const (
+	tcpEstablished = "01"
+	tcpListen      = "0A"
+)
+
+// parseListening returns the inodes of the sockets in the contents of
+// /proc/net/tcp or /proc/net/tcp6 that listen on port.
+func parseListening(data []byte, port int) []string {
+	return parseSockets(data, port, tcpListen)
+}
+
+// parseSockets returns the inodes of the sockets in the contents of
+// /proc/net/tcp or /proc/net/tcp6 in state with port as their local port.
+func parseSockets(data []byte, port int, state string) []string {
+	var inodes []string
+	lines := strings.Split(string(data), "\n")
+	for _, line := range lines[min(1, len(lines)):] {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(line)
+		if len(fields) < 10 || fields[3] != state {
+			continue
+		}
+		_, hexPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		if p, err := strconv.ParseUint(hexPort, 16, 16); err == nil && int(p) == port {
+			inodes = append(inodes, fields[9])
+		}
+	}
+	return inodes
+}
+
+// Tree returns the process pid together with every descendant and every other
+// member of its process group. Group members that were re-parented away from
+// pid (e.g. daemonised grandchildren) are attached directly under the root.
+func Tree(pid int) (*Process, error) {
+	all, err := list()
+	if err != nil {
+		return nil, err
+	}
+	return build(pid, all)
+}
+
+func build(pid int, all []*Process) (*Process, error) {
+	byPID := make(map[int]*Process, len(all))
+	for _, p := range all {
+		byPID[p.PID] = p
+	}
+	root, ok := byPID[pid]
+	if !ok {
+		return nil, errors.New("process not found")
+	}
+
+	included := map[int]bool{pid: true}
+	var inTree func(p *Process) bool
+	inTree = func(p *Process) bool {
+		seen := 0
+		for p != nil && seen < len(all) {
+			if included[p.PID] {
+				return true
+			}
+			p = byPID[p.PPID]
+			seen++
+		}
+		return false
+	}
+
+	for _, p := range all {
+		if p.PID == pid {
+			continue
+		}
+		if !inTree(p) && p.PGID != pid {
+			continue
+		}
+		included[p.PID] = true
+	}
+
+	for _, p := range all {
+		if p.PID == pid || !included[p.PID] {
+			continue
+		}
+		parent, ok := byPID[p.PPID]
+		if !ok || !included[parent.PID] {
+			parent = root
+		}
+		parent.Children = append(parent.Children, p)
+	}
+	return root, nil
+}
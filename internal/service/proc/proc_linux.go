@@ -0,0 +1,156 @@
+//go:build linux
+
+package proc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of utime/stime in /proc/<pid>/stat. It is
+// 100 on every mainstream Linux architecture and can't be read without cgo.
+const clockTicks = 100
+
+var pageSize = uint64(os.Getpagesize())
+
+func list() ([]*Process, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("proc: list: %w", err)
+	}
+	var all []*Process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		p, err := read(pid)
+		if err != nil {
+			// processes come and go while we scan; skip the ones we lost
+			continue
+		}
+		all = append(all, p)
+	}
+	return all, nil
+}
+
+func portOwners(port int) (bool, []*Process, error) {
+	inodes := make(map[string]bool)
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// no IPv6
+				continue
+			}
+			return false, nil, fmt.Errorf("proc: %w", err)
+		}
+		for _, inode := range parseListening(data, port) {
+			inodes["socket:["+inode+"]"] = true
+		}
+	}
+	if len(inodes) == 0 {
+		return false, nil, nil
+	}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return true, nil, fmt.Errorf("proc: list: %w", err)
+	}
+	var owners []*Process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", e.Name(), "fd")
+		fds, err := os.ReadDir(dir)
+		if err != nil {
+			// gone, or not ours to look at
+			continue
+		}
+		for _, fd := range fds {
+			if link, err := os.Readlink(filepath.Join(dir, fd.Name())); err == nil && inodes[link] {
+				if p, err := read(pid); err == nil {
+					owners = append(owners, p)
+				}
+				break
+			}
+		}
+	}
+	return true, owners, nil
+}
+
+func connections(port int) (int, error) {
+	count := 0
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, fmt.Errorf("proc: %w", err)
+		}
+		count += len(parseSockets(data, port, tcpEstablished))
+	}
+	return count, nil
+}
+
+func read(pid int) (*Process, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	data, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseStat(data)
+	if err != nil {
+		return nil, err
+	}
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil && len(cmdline) > 0 {
+		p.Command = strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})))
+	}
+	if fds, err := os.ReadDir(filepath.Join(dir, "fd")); err == nil {
+		p.FDs = len(fds)
+	}
+	return p, nil
+}
+
+// parseStat parses the contents of /proc/<pid>/stat. The command name is
+// wrapped in parentheses and may itself contain spaces or parentheses, so the
+// remaining fields are located relative to the last ')'.
+func parseStat(data []byte) (*Process, error) {
+	s := string(data)
+	open := strings.IndexByte(s, '(')
+	end := strings.LastIndexByte(s, ')')
+	if open < 0 || end < open {
+		return nil, fmt.Errorf("proc: malformed stat")
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(s[:open]))
+	if err != nil {
+		return nil, fmt.Errorf("proc: malformed stat pid: %w", err)
+	}
+	// fields[0] is the state (field 3 in proc(5))
+	fields := strings.Fields(s[end+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("proc: short stat for pid %d", pid)
+	}
+	num := func(i int) uint64 {
+		v, _ := strconv.ParseUint(fields[i], 10, 64)
+		return v
+	}
+	utime, stime := num(11), num(12)
+	return &Process{
+		PID:     pid,
+		PPID:    int(num(1)),
+		PGID:    int(num(2)),
+		Command: "[" + s[open+1:end] + "]",
+		CPUTime: time.Duration(utime+stime) * time.Second / clockTicks,
+		Threads: int(num(17)),
+		RSS:     num(21) * pageSize,
+	}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package proc
+
+func list() ([]*Process, error) {
+	return nil, ErrUnsupported
+}
+
+func portOwners(port int) (bool, []*Process, error) {
+	return false, nil, ErrUnsupported
+}
+
+func connections(port int) (int, error) {
+	return 0, ErrUnsupported
+}
@@ -0,0 +1,110 @@
+package proc
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestBuild_DescendantsAndGroupMembers(t *testing.T) {
+	all := []*Process{
+		{PID: 1, PPID: 0, PGID: 1},
+		{PID: 10, PPID: 1, PGID: 10, RSS: 100, Threads: 1},
+		{PID: 11, PPID: 10, PGID: 10, RSS: 200, Threads: 2},
+		{PID: 12, PPID: 11, PGID: 10, RSS: 300, Threads: 3},
+		// daemonised group member re-parented to init
+		{PID: 13, PPID: 1, PGID: 10, RSS: 400, Threads: 4},
+		// unrelated
+		{PID: 20, PPID: 1, PGID: 20, RSS: 1000, Threads: 10},
+	}
+	root, err := build(10, all)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	u := root.Total()
+	if u.Processes != 4 {
+		t.Fatalf("expected 4 processes in tree, got %d", u.Processes)
+	}
+	if u.RSS != 1000 || u.Threads != 10 {
+		t.Fatalf("unexpected totals: %+v", u)
+	}
+	if len(root.Children) != 2 {
+		t.Fatalf("expected child and re-parented group member under root, got %d children", len(root.Children))
+	}
+
+	depths := make(map[int]int)
+	root.Walk(func(p *Process, depth int) { depths[p.PID] = depth })
+	if depths[12] != 2 || depths[13] != 1 {
+		t.Fatalf("unexpected depths: %v", depths)
+	}
+}
+
+func TestBuild_MissingRoot(t *testing.T) {
+	if _, err := build(99, []*Process{{PID: 1}}); err == nil {
+		t.Fatalf("expected error for missing root")
+	}
+}
+
+func TestTree_IncludesChild(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires /proc")
+	}
+	c := exec.Command("sleep", "30")
+	if err := c.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer func() {
+		_ = c.Process.Kill()
+		_ = c.Wait()
+	}()
+
+	var root *Process
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		var err error
+		root, err = Tree(os.Getpid())
+		if errors.Is(err, ErrUnsupported) {
+			t.Skip(err)
+		}
+		if err != nil {
+			t.Fatalf("tree: %v", err)
+		}
+		if len(root.Children) > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	found := false
+	root.Walk(func(p *Process, _ int) {
+		if p.PID == c.Process.Pid {
+			found = true
+		}
+	})
+	if !found {
+		t.Fatalf("child %d not found in tree of %d", c.Process.Pid, os.Getpid())
+	}
+	if root.RSS == 0 || root.Threads == 0 || root.FDs == 0 {
+		t.Fatalf("expected non-zero usage for self, got %+v", root)
+	}
+}
+
+func TestParseListening(t *testing.T) {
+	data := []byte(`  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 4312 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:1F90 0100007F:D431 01 00000000:00000000 00:00000000 00000000  1000        0 4313 1 0000000000000000 20 4 30 10 -1
+   2: 00000000:0050 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 4314 1 0000000000000000 100 0 0 10 0
+`)
+	if got := parseListening(data, 8080); len(got) != 1 || got[0] != "4312" {
+		t.Errorf("expected only the listening socket on 8080, got %v", got)
+	}
+	if got := parseListening(data, 9090); len(got) != 0 {
+		t.Errorf("expected nothing on 9090, got %v", got)
+	}
+	if got := parseSockets(data, 8080, tcpEstablished); len(got) != 1 || got[0] != "4313" {
+		t.Errorf("expected the connection to 8080, got %v", got)
+	}
+}
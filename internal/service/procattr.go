@@ -0,0 +1,274 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/mertenvg/blade/internal/service/limits"
+)
+
+// ProcExecCommand is the hidden blade command that applies the limits, nice,
+// ionice, umask, user and group of a service to itself before replacing
+// itself with it, so the service never runs without them. The umask is
+// process wide, so blade can't set it around the fork without affecting its
+// own files either.
+const ProcExecCommand = "__proc-exec"
+
+// procAttrs are the resolved user, group, umask, nice and ionice settings of
+// a service. Validate resolves them so that unknown users or bad values are
+// reported with the rest of the configuration errors, and every start
+// resolves them again for the command it builds.
+type procAttrs struct {
+	credential *syscall.Credential
+	username   string
+	home       string
+	umask      *int
+	ioprio     int
+}
+
+func (s *S) resolveProcAttrs() (procAttrs, error) {
+	var attrs procAttrs
+
+	if s.User != "" {
+		u, err := lookupUser(s.User)
+		if err != nil {
+			return attrs, fmt.Errorf("user: %w", err)
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		attrs.credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+		attrs.username = u.Username
+		attrs.home = u.HomeDir
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+					attrs.credential.Groups = append(attrs.credential.Groups, uint32(g))
+				}
+			}
+		}
+	}
+
+	if s.Group != "" {
+		gid, err := lookupGroup(s.Group)
+		if err != nil {
+			return attrs, fmt.Errorf("group: %w", err)
+		}
+		if attrs.credential == nil {
+			attrs.credential = &syscall.Credential{Uid: uint32(os.Getuid()), NoSetGroups: true}
+		}
+		attrs.credential.Gid = gid
+	}
+
+	if attrs.credential != nil && os.Geteuid() != 0 {
+		if int(attrs.credential.Uid) != os.Geteuid() || int(attrs.credential.Gid) != os.Getegid() {
+			return attrs, fmt.Errorf("user/group: blade must run as root to start services as another user or group")
+		}
+	}
+
+	if s.Umask != "" {
+		mask, err := strconv.ParseUint(s.Umask, 8, 32)
+		if err != nil || mask > 0o777 {
+			return attrs, fmt.Errorf("umask: expected an octal value such as 022, got '%s'", s.Umask)
+		}
+		m := int(mask)
+		attrs.umask = &m
+	}
+
+	if s.Nice < -20 || s.Nice > 19 {
+		return attrs, fmt.Errorf("nice: must be between -20 and 19, got %d", s.Nice)
+	}
+	if s.Nice < 0 && os.Geteuid() != 0 {
+		return attrs, fmt.Errorf("nice: blade must run as root to raise priority (nice %d)", s.Nice)
+	}
+
+	if s.IONice != "" {
+		prio, err := parseIONice(s.IONice)
+		if err != nil {
+			return attrs, fmt.Errorf("ionice: %w", err)
+		}
+		attrs.ioprio = prio
+	}
+
+	return attrs, nil
+}
+
+// lookupUser accepts a user name or a numeric uid.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+		// numeric ids without a passwd entry are valid, e.g. in containers
+		return &user.User{Uid: name, Gid: name, Username: name, HomeDir: "/"}, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user '%s'", name)
+	}
+	return u, nil
+}
+
+// lookupGroup accepts a group name or a numeric gid.
+func lookupGroup(name string) (uint32, error) {
+	if gid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown group '%s'", name)
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("group '%s' has non-numeric gid '%s'", name, g.Gid)
+	}
+	return uint32(gid), nil
+}
+
+const (
+	ioprioClassRealtime   = 1
+	ioprioClassBestEffort = 2
+	ioprioClassIdle       = 3
+	ioprioClassShift      = 13
+)
+
+// parseIONice parses "<class>[:<level>]" where class is realtime, best-effort
+// or idle and level is 0 (highest) to 7 (lowest). The level defaults to 4 and
+// is ignored for idle.
+func parseIONice(v string) (int, error) {
+	class, level, hasLevel := strings.Cut(v, ":")
+	var c int
+	switch strings.ToLower(strings.TrimSpace(class)) {
+	case "realtime", "rt":
+		c = ioprioClassRealtime
+	case "best-effort", "be":
+		c = ioprioClassBestEffort
+	case "idle":
+		c = ioprioClassIdle
+	default:
+		return 0, fmt.Errorf("unknown class '%s', expected realtime, best-effort or idle", class)
+	}
+	if c == ioprioClassRealtime && os.Geteuid() != 0 {
+		return 0, fmt.Errorf("blade must run as root to use the realtime class")
+	}
+	l := 4
+	if hasLevel {
+		n, err := strconv.Atoi(strings.TrimSpace(level))
+		if err != nil || n < 0 || n > 7 {
+			return 0, fmt.Errorf("level must be between 0 and 7, got '%s'", level)
+		}
+		l = n
+	}
+	if c == ioprioClassIdle {
+		l = 0
+	}
+	if !ioniceSupported {
+		return 0, fmt.Errorf("not supported on this platform")
+	}
+	return c<<ioprioClassShift | l, nil
+}
+
+// execSpec is what ProcExecCommand applies before the exec.
+type execSpec struct {
+	Credential *syscall.Credential `json:"credential,omitempty"`
+	Umask      *int                `json:"umask,omitempty"`
+	Nice       int                 `json:"nice,omitempty"`
+	IOPrio     int                 `json:"ioprio,omitempty"`
+	Limits     *limits.L           `json:"limits,omitempty"`
+	// Memory applies the memory limit as an rlimit, when there is no cgroup
+	Memory bool `json:"memory,omitempty"`
+}
+
+// applyProcAttrs starts c through ProcExecCommand when the service has a
+// user, group, umask, nice, ionice or rlimits, and sets the identity
+// variables matching its user. It is applied before passSockets, so that
+// blade only ever runs as itself.
+func (s *S) applyProcAttrs(c *exec.Cmd) {
+	if s.attrs.username != "" {
+		c.Env = append(c.Environ(),
+			"USER="+s.attrs.username,
+			"LOGNAME="+s.attrs.username,
+			"HOME="+s.attrs.home,
+		)
+	}
+	spec := execSpec{
+		Credential: s.attrs.credential,
+		Umask:      s.attrs.umask,
+		Nice:       s.Nice,
+		IOPrio:     s.attrs.ioprio,
+	}
+	if l := s.Limits; l != nil && (l.NoFile != 0 || l.NProc != 0 || s.cgroup == nil && l.Memory != "") {
+		spec.Limits = l
+		spec.Memory = s.cgroup == nil
+	}
+	if spec == (execSpec{}) || c.Err != nil {
+		return
+	}
+	if ExecShim == "" {
+		c.Err = errors.New("user, group, umask, nice, ionice and limits: the blade binary to apply them through is unknown")
+		return
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		c.Err = err
+		return
+	}
+	c.Args = append([]string{ExecShim, ProcExecCommand, string(data), c.Path}, c.Args...)
+	c.Path = ExecShim
+}
+
+// ProcExec runs the command in args, an execSpec, a path and the arguments
+// including the name it was started as, in place of the current process
+// after applying the spec. Nice and ionice are set for the process group,
+// which holds every thread of the process.
+func ProcExec(args []string) error {
+	if len(args) < 3 {
+		return errors.New("proc-exec: missing command")
+	}
+	var spec execSpec
+	if err := json.Unmarshal([]byte(args[0]), &spec); err != nil {
+		return fmt.Errorf("proc-exec: %w", err)
+	}
+	if err := spec.Limits.ApplyRlimits(0, spec.Memory); errors.Is(err, limits.ErrUnsupported) {
+		fmt.Fprintln(os.Stderr, "blade: limits:", err)
+	} else if err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+	if spec.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PGRP, 0, spec.Nice); err != nil {
+			return fmt.Errorf("nice: %w", err)
+		}
+	}
+	if spec.IOPrio != 0 {
+		if err := ioprioSetGroup(0, spec.IOPrio); err != nil {
+			return fmt.Errorf("ionice: %w", err)
+		}
+	}
+	if spec.Umask != nil {
+		syscall.Umask(*spec.Umask)
+	}
+	if cred := spec.Credential; cred != nil {
+		if !cred.NoSetGroups {
+			groups := make([]int, len(cred.Groups))
+			for i, g := range cred.Groups {
+				groups[i] = int(g)
+			}
+			if err := syscall.Setgroups(groups); err != nil {
+				return fmt.Errorf("group: %w", err)
+			}
+		}
+		if err := syscall.Setgid(int(cred.Gid)); err != nil {
+			return fmt.Errorf("group: %w", err)
+		}
+		if err := syscall.Setuid(int(cred.Uid)); err != nil {
+			return fmt.Errorf("user: %w", err)
+		}
+	}
+	return syscall.Exec(args[1], args[2:], os.Environ())
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package service
+
+import "syscall"
+
+const (
+	ioniceSupported = true
+	ioprioWhoPgrp   = 2
+)
+
+// ioprioSetGroup sets the I/O scheduling class and level of every process in
+// the group pgid, or of the caller's group when pgid is 0.
+func ioprioSetGroup(pgid, prio int) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoPgrp, uintptr(pgid), uintptr(prio))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package service
+
+import "errors"
+
+// ioniceSupported is false outside Linux; ioprio_set(2) is Linux specific.
+const ioniceSupported = false
+
+func ioprioSetGroup(pgid, prio int) error {
+	return errors.New("ionice is not supported on this platform")
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/mertenvg/blade/internal/service/limits"
+)
+
+// TestMain lets the test binary stand in for the blade binary as ExecShim.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case ListenExecCommand:
+			_ = ListenExec(os.Args[2:])
+			os.Exit(127)
+		case ProcExecCommand:
+			if err := ProcExec(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			os.Exit(127)
+		}
+	}
+	if exe, err := os.Executable(); err == nil {
+		ExecShim = exe
+	}
+	os.Exit(m.Run())
+}
+
+func TestValidate_UnknownUserFails(t *testing.T) {
+	s := &S{Name: "svc", User: "no-such-user-blade-test"}
+	err := s.Validate()
+	if err == nil || !strings.Contains(err.Error(), "unknown user") {
+		t.Fatalf("expected unknown user error at validation, got %v", err)
+	}
+}
+
+func TestValidate_UnknownGroupFails(t *testing.T) {
+	s := &S{Name: "svc", Group: "no-such-group-blade-test"}
+	if err := s.Validate(); err == nil || !strings.Contains(err.Error(), "unknown group") {
+		t.Fatalf("expected unknown group error at validation, got %v", err)
+	}
+}
+
+func TestValidate_ProcAttrValues(t *testing.T) {
+	cases := []struct {
+		name string
+		s    *S
+		ok   bool
+	}{
+		{"octal umask", &S{Umask: "027"}, true},
+		{"non-octal umask", &S{Umask: "089"}, false},
+		{"umask out of range", &S{Umask: "1777"}, false},
+		{"nice too low", &S{Nice: -21}, false},
+		{"nice too high", &S{Nice: 20}, false},
+		{"nice", &S{Nice: 10}, true},
+		{"bad ionice class", &S{IONice: "sometimes"}, false},
+		{"bad ionice level", &S{IONice: "best-effort:9"}, false},
+	}
+	for _, tc := range cases {
+		err := tc.s.Validate()
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestParseIONice(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("ionice is linux only")
+	}
+	cases := map[string]int{
+		"idle":          3 << 13,
+		"idle:5":        3 << 13,
+		"best-effort":   2<<13 | 4,
+		"be:7":          2<<13 | 7,
+		"best-effort:0": 2 << 13,
+	}
+	for in, want := range cases {
+		got, err := parseIONice(in)
+		if err != nil {
+			t.Fatalf("parseIONice(%q): %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("parseIONice(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+// TestRun_Umask checks that the umask applies to the command but not to the
+// test process starting it.
+func TestRun_Umask(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix sh")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "umask.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\numask\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.log")
+	s := &S{Name: "svc", Umask: "077", Output: Output{Stdout: "file:" + out}}
+	if err := s.run(context.Background(), "sh "+script); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if data, _ := os.ReadFile(out); strings.TrimSpace(string(data)) != "0077" {
+		t.Fatalf("expected the command to run with umask 0077, got %q", data)
+	}
+	own := syscall.Umask(0o022)
+	syscall.Umask(own)
+	if own == 0o077 {
+		t.Fatal("the umask of the service leaked into the process starting it")
+	}
+
+	defer func(shim string) { ExecShim = shim }(ExecShim)
+	ExecShim = ""
+	if err := s.run(context.Background(), "sh "+script); err == nil || !strings.Contains(err.Error(), "blade binary") {
+		t.Fatalf("expected a umask without a shim to fail the start, got %v", err)
+	}
+}
+
+// TestRun_ProcAttrsBeforeExec checks that nice and limits are in place when
+// the command starts, rather than applied to it once it runs.
+func TestRun_ProcAttrsBeforeExec(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("rlimits are linux only")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "limits.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nnice\nulimit -n\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.log")
+	s := &S{Name: "svc", Nice: 5, Limits: &limits.L{NoFile: 64}, Output: Output{Stdout: "file:" + out}, Env: []EnvValue{{Name: "PATH"}}}
+	if err := s.run(context.Background(), "sh "+script); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if data, _ := os.ReadFile(out); strings.TrimSpace(string(data)) != "5\n64" {
+		t.Fatalf("expected nice 5 and nofile 64, got %q", data)
+	}
+}
+
+// TestRun_AsUserWithUmask runs a command as nobody with a custom umask, a
+// raised priority and a nofile limit, and checks them from the child's point
+// of view. Raising the priority needs root, so it has to happen before the
+// shim drops to nobody.
+func TestRun_AsUserWithUmask(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix sh")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("requires root to switch user")
+	}
+	dir := t.TempDir()
+	// the script has to be readable by nobody
+	for _, d := range []string{dir, filepath.Dir(dir)} {
+		if err := os.Chmod(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := filepath.Join(dir, "id.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nid -un\numask\necho $HOME\nnice\nulimit -n\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.log")
+	s := &S{
+		Name:   "svc",
+		User:   "nobody",
+		Umask:  "027",
+		Nice:   -5,
+		Limits: &limits.L{NoFile: 64},
+		Output: Output{Stdout: "file:" + out},
+		Env:    []EnvValue{{Name: "PATH"}},
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if err := s.run(context.Background(), "sh "+script); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 5 || lines[0] != "nobody" || lines[1] != "0027" || lines[3] != "-5" || lines[4] != "64" {
+		t.Fatalf("expected nobody with umask 0027, nice -5 and nofile 64, got %q", data)
+	}
+	if lines[2] == os.Getenv("HOME") {
+		t.Fatalf("expected HOME of nobody, got blade's %q", lines[2])
+	}
+}
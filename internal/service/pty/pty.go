@@ -0,0 +1,14 @@
+package pty
+
+import "errors"
+
+// ErrUnsupported is returned by Open on platforms without pseudo-terminals.
+var ErrUnsupported = errors.New("pseudo-terminals are not supported on this platform")
+
+// Winsize is the terminal size in characters, as used by TIOCGWINSZ.
+type Winsize struct {
+	Rows uint16
+	Cols uint16
+	X    uint16
+	Y    uint16
+}
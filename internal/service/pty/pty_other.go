@@ -0,0 +1,26 @@
+//go:build !linux && !darwin
+
+package pty
+
+import (
+	"os"
+	"syscall"
+)
+
+func Open() (*os.File, *os.File, error) {
+	return nil, nil, ErrUnsupported
+}
+
+func GetSize(f *os.File) (*Winsize, error) {
+	return nil, ErrUnsupported
+}
+
+func SetSize(f *os.File, ws *Winsize) error {
+	return ErrUnsupported
+}
+
+func Follow(master *os.File) (stop func()) {
+	return func() {}
+}
+
+func Controlling(attr *syscall.SysProcAttr) {}
@@ -0,0 +1,43 @@
+package pty
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOpen_SetAndGetSize(t *testing.T) {
+	master, slave, err := Open()
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	want := &Winsize{Rows: 42, Cols: 132}
+	if err := SetSize(master, want); err != nil {
+		t.Fatalf("set size: %v", err)
+	}
+	got, err := GetSize(slave)
+	if err != nil {
+		t.Fatalf("get size: %v", err)
+	}
+	if got.Rows != want.Rows || got.Cols != want.Cols {
+		t.Fatalf("slave size %dx%d, want %dx%d", got.Cols, got.Rows, want.Cols, want.Rows)
+	}
+
+	if _, err := slave.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("write slave: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, err := master.Read(buf)
+	if err != nil {
+		t.Fatalf("read master: %v", err)
+	}
+	// the line discipline translates \n into \r\n
+	if got := string(buf[:n]); got != "hello\r\n" {
+		t.Fatalf("read %q from master", got)
+	}
+}
@@ -0,0 +1,72 @@
+//go:build linux || darwin
+
+package pty
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(f *os.File, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// GetSize returns the window size of the terminal f.
+func GetSize(f *os.File) (*Winsize, error) {
+	var ws Winsize
+	if err := ioctl(f, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		return nil, err
+	}
+	return &ws, nil
+}
+
+// SetSize sets the window size of the terminal f.
+func SetSize(f *os.File, ws *Winsize) error {
+	return ioctl(f, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(ws)))
+}
+
+// Follow copies the window size of blade's own terminal to master now and on
+// every SIGWINCH until the returned stop function is called. It does nothing
+// when blade's stdout isn't a terminal.
+func Follow(master *os.File) (stop func()) {
+	sync := func() {
+		if ws, err := GetSize(os.Stdout); err == nil {
+			_ = SetSize(master, ws)
+		}
+	}
+	sync()
+
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, syscall.SIGWINCH)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ch:
+				sync()
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+// Controlling makes the child's stdin, which must be the slave end of a pty,
+// its controlling terminal in a new session. The child becomes a session and
+// process group leader, so signalling -pid still reaches its whole group.
+func Controlling(attr *syscall.SysProcAttr) {
+	attr.Setpgid = false
+	attr.Setsid = true
+	attr.Setctty = true
+	attr.Ctty = 0
+}
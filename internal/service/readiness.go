@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Restart strategies.
+const (
+	// RestartStop stops the running instance before starting the new one.
+	RestartStop = "stop-start"
+	// RestartReplace starts the new instance next to the running one and only
+	// stops the old one once the new one is ready.
+	RestartReplace = "replace"
+)
+
+// readinessInterval is how often a readiness probe is retried.
+const readinessInterval = 200 * time.Millisecond
+
+// defaultReadinessTimeout applies when the probe has no timeout.
+const defaultReadinessTimeout = 30
+
+// Readiness tells when a newly started instance of the service is ready to
+// take over from the one it replaces.
+type Readiness struct {
+	// TCP is an address that accepts connections once the service is ready.
+	TCP string `yaml:"tcp,omitempty" json:"tcp,omitempty"`
+	// HTTP is a URL that answers with a 2xx or 3xx status once the service is
+	// ready.
+	HTTP string `yaml:"http,omitempty" json:"http,omitempty"`
+	// Notify waits for the service to send READY=1 to NOTIFY_SOCKET, as with
+	// systemd's sd_notify.
+	Notify bool `yaml:"notify,omitempty" json:"notify,omitempty"`
+	// Timeout is how many seconds the service gets to become ready.
+	Timeout int `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+func (r *Readiness) InheritFrom(parent *Readiness) *Readiness {
+	if parent != nil {
+		return parent
+	}
+	return r
+}
+
+func (r *Readiness) validate() error {
+	probes := 0
+	for _, set := range []bool{r.TCP != "", r.HTTP != "", r.Notify} {
+		if set {
+			probes++
+		}
+	}
+	switch {
+	case probes == 0:
+		return errors.New("readiness: needs a tcp, http or notify probe")
+	case probes > 1:
+		return errors.New("readiness: takes only one of tcp, http and notify")
+	case r.Timeout < 0:
+		return errors.New("readiness: timeout can't be negative")
+	}
+	return nil
+}
+
+func (r *Readiness) timeout() time.Duration {
+	if r.Timeout == 0 {
+		return defaultReadinessTimeout * time.Second
+	}
+	return time.Duration(r.Timeout) * time.Second
+}
+
+// probe makes a single attempt at reaching the service.
+func (r *Readiness) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if r.TCP != "" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", r.TCP)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.HTTP, nil)
+	if err != nil {
+		return err
+	}
+	// a redirect counts as ready, it needn't be followed
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s answered %s", r.HTTP, resp.Status)
+	}
+	return nil
+}
+
+// errStopping is returned by waitReady when the service is stopped while it
+// waits.
+var errStopping = errors.New("service is stopping")
+
+// waitReady waits until the readiness probe of the service passes for in. It
+// fails when the timeout passes first, when in exits, or when the service is
+// stopped. Restarts requested meanwhile are handled once it returns.
+func (s *S) waitReady(ctx context.Context, in *instance) error {
+	r := s.Readiness
+	ctx, cancel := context.WithTimeout(ctx, r.timeout())
+	defer cancel()
+
+	requeue := false
+	defer func() {
+		if requeue {
+			select {
+			case s.restartCh <- empty{}:
+			default:
+			}
+		}
+	}()
+
+	var last error
+	for {
+		if r.Notify {
+			select {
+			case <-in.notified:
+				return nil
+			default:
+			}
+		} else if last = r.probe(ctx); last == nil {
+			return nil
+		}
+		select {
+		case <-in.done:
+			if in.err != nil {
+				return fmt.Errorf("exited before it was ready: %w", in.err)
+			}
+			return errors.New("exited before it was ready")
+		case <-in.notified:
+		case <-s.restartCh:
+			if s.DNR {
+				return errStopping
+			}
+			requeue = true
+		case <-ctx.Done():
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return ctx.Err()
+			}
+			if last != nil {
+				return fmt.Errorf("not ready after %s: %w", r.timeout(), last)
+			}
+			return fmt.Errorf("not ready after %s", r.timeout())
+		case <-time.After(readinessInterval):
+		}
+	}
+}
+
+// notifySocket is the socket a service with a notify probe reports READY=1
+// on. Every instance gets its own, so the message can't be taken for one from
+// the instance being replaced.
+type notifySocket struct {
+	dir  string
+	conn *net.UnixConn
+}
+
+func listenNotify() (*notifySocket, error) {
+	dir, err := os.MkdirTemp("", "blade-notify-")
+	if err != nil {
+		return nil, err
+	}
+	addr := &net.UnixAddr{Name: filepath.Join(dir, "notify.sock"), Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &notifySocket{dir: dir, conn: conn}, nil
+}
+
+func (n *notifySocket) path() string {
+	return filepath.Join(n.dir, "notify.sock")
+}
+
+// receive closes ready once READY=1 arrives. It returns when the socket is
+// closed.
+func (n *notifySocket) receive(ready chan<- empty) {
+	buf := make([]byte, 4096)
+	for {
+		size, err := n.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		// a message is a newline separated list of assignments
+		for _, line := range strings.Split(string(buf[:size]), "\n") {
+			if line == "READY=1" {
+				close(ready)
+				return
+			}
+		}
+	}
+}
+
+func (n *notifySocket) Close() {
+	n.conn.Close()
+	os.RemoveAll(n.dir)
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mertenvg/blade/pkg/blade"
+)
+
+// TestHelperReadyServer is invoked as a subprocess by the replace tests. When
+// BLADE_TEST_HELPER=ready-server it serves the socket blade passed it, reports
+// readiness unless BLADE_TEST_READY=never, and appends its start and stop to
+// the file in BLADE_TEST_EVENTS.
+func TestHelperReadyServer(t *testing.T) {
+	if os.Getenv("BLADE_TEST_HELPER") != "ready-server" {
+		return
+	}
+	event := func(what string) {
+		f, err := os.OpenFile(os.Getenv("BLADE_TEST_EVENTS"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			os.Exit(1)
+		}
+		fmt.Fprintf(f, "%s %d\n", what, os.Getpid())
+		f.Close()
+	}
+
+	l, err := blade.Listen("http", "tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, os.Getpid())
+	}))
+	event("started")
+	if os.Getenv("BLADE_TEST_READY") != "never" {
+		if err := blade.Ready(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	<-sig
+	event("stopped")
+	os.Exit(0)
+}
+
+// readyServer returns a service running TestHelperReadyServer with the
+// replace strategy, and the file its events are written to.
+func readyServer(t *testing.T, ready string) (*S, string) {
+	testBin, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable: %v", err)
+	}
+	dir := t.TempDir()
+	events := filepath.Join(dir, "events.log")
+	s := &S{
+		Name:            "web",
+		Run:             testBin + " -test.run=^TestHelperReadyServer$",
+		Sockets:         []Socket{{Name: "http", Listen: "127.0.0.1:0"}},
+		RestartStrategy: RestartReplace,
+		Readiness:       &Readiness{Notify: true, Timeout: 2},
+		Env: []EnvValue{
+			{Name: "BLADE_TEST_HELPER", Value: strPtr("ready-server")},
+			{Name: "BLADE_TEST_EVENTS", Value: strPtr(events)},
+			{Name: "BLADE_TEST_READY", Value: strPtr(ready)},
+		},
+		Output: Output{Stdout: "file:" + filepath.Join(dir, "stdout.log"), Stderr: "file:" + filepath.Join(dir, "stderr.log")},
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return s, events
+}
+
+// waitEvents polls the events file until it holds n lines.
+func waitEvents(t *testing.T, path string, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		lines := strings.Fields(strings.ReplaceAll(string(data), " ", "_"))
+		if len(lines) >= n {
+			return lines
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d events, got %q", n, lines)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestReplace_StopsOldInstanceOnceNewIsReady(t *testing.T) {
+	s, events := readyServer(t, "always")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+	s.Start(ctx)
+
+	first := waitEvents(t, events, 1)[0]
+	s.Restart()
+	got := waitEvents(t, events, 3)
+
+	oldPid := strings.TrimPrefix(first, "started_")
+	if !strings.HasPrefix(got[1], "started_") || got[1] == first || got[2] != "stopped_"+oldPid {
+		t.Fatalf("expected the new instance to start before the old one stopped, got %q", got)
+	}
+	newPid := strings.TrimPrefix(got[1], "started_")
+	if _, _, pid := s.Status(); pid != "("+newPid+")" {
+		t.Errorf("expected status to show the new pid %s, got %s", newPid, pid)
+	}
+}
+
+func TestReplace_FallsBackToStopStartWhenNotReady(t *testing.T) {
+	s, events := readyServer(t, "never")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+	s.Start(ctx)
+
+	first := waitEvents(t, events, 1)[0]
+	s.Restart()
+	got := waitEvents(t, events, 5)
+
+	oldPid := strings.TrimPrefix(first, "started_")
+	replacement := strings.TrimPrefix(got[1], "started_")
+	want := []string{first, "started_" + replacement, "stopped_" + replacement, "stopped_" + oldPid}
+	for i, w := range want {
+		if got[i] != w {
+			t.Fatalf("expected the replacement to be stopped, then the old instance, got %q", got)
+		}
+	}
+	if !strings.HasPrefix(got[4], "started_") {
+		t.Errorf("expected a fresh start after the fallback, got %q", got)
+	}
+}
+
+func TestReplace_StopsWhileWaitingForReadiness(t *testing.T) {
+	for name, stop := range map[string]func(*S, context.CancelFunc){
+		"exit":   func(s *S, _ context.CancelFunc) { s.Exit() },
+		"cancel": func(_ *S, cancel context.CancelFunc) { cancel() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, events := readyServer(t, "never")
+			s.Readiness.Timeout = 30
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			s.Start(ctx)
+
+			waitEvents(t, events, 1)
+			s.Restart()
+			waitEvents(t, events, 2)
+
+			stopped := make(chan empty)
+			go func() {
+				stop(s, cancel)
+				s.Wait()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-time.After(10 * time.Second):
+				t.Fatal("service didn't stop while its replacement waited for readiness")
+			}
+		})
+	}
+}
+
+func TestValidate_RestartStrategy(t *testing.T) {
+	sockets := []Socket{{Name: "http", Listen: ":8080"}}
+	for _, tc := range []struct {
+		strategy  string
+		readiness *Readiness
+		sockets   []Socket
+		err       string
+	}{
+		{"", nil, nil, ""},
+		{RestartStop, nil, nil, ""},
+		{RestartReplace, &Readiness{HTTP: "http://localhost:8080/health"}, nil, ""},
+		{RestartReplace, nil, nil, "restartStrategy: replace needs a readiness probe to know when the new instance can take over"},
+		{"rolling", nil, nil, "restartStrategy: unknown strategy 'rolling', use stop-start or replace"},
+		{RestartReplace, &Readiness{}, nil, "readiness: needs a tcp, http or notify probe"},
+		{RestartReplace, &Readiness{TCP: ":8080", Notify: true}, nil, "readiness: takes only one of tcp, http and notify"},
+		{RestartReplace, &Readiness{Notify: true}, sockets, ""},
+		{RestartReplace, &Readiness{TCP: ":8080"}, sockets, "readiness: with sockets only notify tells when the new instance is ready, as tcp and http probes are answered by the old one"},
+		{RestartReplace, &Readiness{HTTP: "http://localhost:8080/health"}, sockets, "readiness: with sockets only notify tells when the new instance is ready, as tcp and http probes are answered by the old one"},
+	} {
+		s := &S{Name: "web", RestartStrategy: tc.strategy, Readiness: tc.readiness, Sockets: tc.sockets}
+		err := s.Validate()
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("%q %+v: expected error %q, got %v", tc.strategy, tc.readiness, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/mertenvg/blade/internal/service/watcher"
+)
+
+// ReplicaIndexEnv is the variable holding the index of a replica, from 1.
+const ReplicaIndexEnv = "BLADE_REPLICA_INDEX"
+
+// ReplicaName is the name of replica index of the service called name.
+func ReplicaName(name string, index int) string {
+	return fmt.Sprintf("%s#%d", name, index)
+}
+
+// ReplicaSet returns the instances blade runs for a service with Replicas
+// set, named like worker#1, or nil when it runs as a single one. Each is a
+// service of its own, inheriting everything from s, so it gets ports of its
+// own when they are allocated afterwards.
+func (s *S) ReplicaSet() ([]*S, error) {
+	if s.Replicas <= 1 || s.ReplicaOf != "" {
+		return nil, nil
+	}
+	if err := s.validateReplicas(); err != nil {
+		return nil, err
+	}
+	set := make([]*S, s.Replicas)
+	for i := range set {
+		r := &S{
+			Name:      ReplicaName(s.Name, i+1),
+			From:      s.From,
+			Source:    s.Source,
+			Profiles:  s.Profiles,
+			ReplicaOf: s.Name,
+		}
+		r.InheritFrom(s)
+		if s.Watch != nil {
+			// every replica starts and stops a watcher of its own
+			r.Watch = &watcher.W{FS: s.Watch.FS}
+		}
+		index := strconv.Itoa(i + 1)
+		r.Env = append(r.Env, EnvValue{Name: ReplicaIndexEnv, Value: &index, Origin: fmt.Sprintf("replica %s of %s", index, s.Name)})
+		set[i] = r
+	}
+	return set, nil
+}
+
+func (s *S) validateReplicas() error {
+	if s.Replicas < 0 {
+		return errors.New("replicas can't be negative")
+	}
+	if s.Replicas <= 1 || s.ReplicaOf != "" {
+		// replicas are checked before they are made, by then their ports
+		// are picked
+		return nil
+	}
+	for _, p := range s.Ports {
+		if p.Port != 0 {
+			return fmt.Errorf("replicas: port %s is fixed at %d, leave the number out so every replica gets one of its own", p.Name, p.Port)
+		}
+	}
+	if len(s.Sockets) > 0 {
+		return errors.New("replicas: sockets can't be shared between replicas, use ports instead")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/mertenvg/blade/internal/service/watcher"
+)
+
+func TestReplicaSet(t *testing.T) {
+	s := &S{Name: "worker", Run: "./worker", Replicas: 2, Watch: &watcher.W{}, Env: []EnvValue{{Name: "QUEUE", Value: strPtr("jobs")}}}
+	set, err := s.ReplicaSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 2 {
+		t.Fatalf("expected 2 replicas, got %d", len(set))
+	}
+	for i, r := range set {
+		index := string(rune('1' + i))
+		if r.Name != "worker#"+index || r.ReplicaOf != "worker" || r.Run != "./worker" {
+			t.Errorf("unexpected replica %s of %q running %q", r.Name, r.ReplicaOf, r.Run)
+		}
+		if len(r.Env) != 2 || r.Env[0].Name != "QUEUE" || r.Env[1].Name != ReplicaIndexEnv || *r.Env[1].Value != index {
+			t.Errorf("%s: unexpected env %+v", r.Name, r.Env)
+		}
+		if r.Watch == s.Watch {
+			t.Errorf("%s: expected a watcher of its own", r.Name)
+		}
+		if set, _ := r.ReplicaSet(); set != nil {
+			t.Errorf("%s: a replica shouldn't be expanded again", r.Name)
+		}
+	}
+	if set, _ := (&S{Name: "api", Replicas: 1}).ReplicaSet(); set != nil {
+		t.Error("expected a single replica to run as the service itself")
+	}
+	if _, err := (&S{Name: "api", Replicas: 2, Ports: []Port{{Name: "http", Port: 8080}}}).ReplicaSet(); err == nil {
+		t.Error("expected replicas with a fixed port to be refused")
+	}
+}
+
+func TestValidate_Replicas(t *testing.T) {
+	for _, tc := range []struct {
+		s   *S
+		err string
+	}{
+		{&S{Replicas: 3, Ports: []Port{{Name: "http"}}}, ""},
+		{&S{Replicas: 1, Ports: []Port{{Name: "http", Port: 8080}}}, ""},
+		{&S{Replicas: -1}, "replicas can't be negative"},
+		{&S{Replicas: 3, Ports: []Port{{Name: "http", Port: 8080}}}, "replicas: port http is fixed at 8080, leave the number out so every replica gets one of its own"},
+		{&S{Replicas: 3, Sockets: []Socket{{Name: "http", Listen: ":8080"}}}, "replicas: sockets can't be shared between replicas, use ports instead"},
+	} {
+		err := tc.s.Validate()
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mertenvg/blade/pkg/coalesce"
+	"github.com/mertenvg/blade/pkg/redact"
+)
+
+// ValueFrom reads the value of an env variable each time the service starts,
+// so secrets stay out of the configuration files.
+type ValueFrom struct {
+	Command string `yaml:"command,omitempty"`
+	File    string `yaml:"file,omitempty"`
+}
+
+func (v *ValueFrom) validate() error {
+	switch {
+	case v.Command == "" && v.File == "":
+		return errors.New("valueFrom needs a command or a file")
+	case v.Command != "" && v.File != "":
+		return errors.New("valueFrom takes either a command or a file, not both")
+	}
+	return nil
+}
+
+// read runs the command, or reads the file, relative to dir. A single
+// trailing newline is dropped, as most tools print one.
+func (v *ValueFrom) read(ctx context.Context, dir string) (string, error) {
+	var out []byte
+	if v.File != "" {
+		path := v.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		out = data
+	} else {
+		parts := strings.Fields(v.Command)
+		c := exec.CommandContext(ctx, parts[0], parts[1:]...)
+		c.Dir = dir
+		var stderr bytes.Buffer
+		c.Stderr = &stderr
+		data, err := c.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", fmt.Errorf("%s: %w: %s", v.Command, err, msg)
+			}
+			return "", fmt.Errorf("%s: %w", v.Command, err)
+		}
+		out = data
+	}
+	out = bytes.TrimSuffix(out, []byte("\n"))
+	return string(bytes.TrimSuffix(out, []byte("\r"))), nil
+}
+
+// IsSecret reports whether the value must be masked. Values read with
+// valueFrom always are.
+func (e EnvValue) IsSecret() bool {
+	return e.Secret || e.ValueFrom != nil
+}
+
+func (e EnvValue) validate() error {
+	if e.ValueFrom == nil {
+		return nil
+	}
+	if e.Value != nil {
+		return fmt.Errorf("env %s: value and valueFrom can't be used together", e.Name)
+	}
+	if err := e.ValueFrom.validate(); err != nil {
+		return fmt.Errorf("env %s: %w", e.Name, err)
+	}
+	return nil
+}
+
+// HasSecrets reports whether any env value of the service is a secret.
+func (s *S) HasSecrets() bool {
+	for _, e := range s.Env {
+		if e.IsSecret() {
+			return true
+		}
+	}
+	return false
+}
+
+// secretValues is the result of reading the valueFrom values of a service.
+type secretValues struct {
+	values map[string]string
+	err    error
+}
+
+// startSecrets returns the valueFrom values for the current start, reading
+// them on first use, so the once, before and run commands of a start share a
+// single read.
+func (s *S) startSecrets(ctx context.Context) (map[string]string, error) {
+	if s.secrets == nil {
+		values, err := s.readSecrets(ctx)
+		s.secrets = &secretValues{values, err}
+	}
+	return s.secrets.values, s.secrets.err
+}
+
+// forgetSecrets makes the next start read the valueFrom values again, so
+// changed secrets apply on restart.
+func (s *S) forgetSecrets() {
+	s.secrets = nil
+}
+
+// readSecrets resolves the valueFrom env values of the service and registers
+// every secret value for masking.
+func (s *S) readSecrets(ctx context.Context) (map[string]string, error) {
+	values := make(map[string]string)
+	for i, e := range s.Env {
+		if slices.ContainsFunc(s.Env[i+1:], func(later EnvValue) bool { return later.Name == e.Name }) {
+			// overridden further down, e.g. by the inheriting service
+			continue
+		}
+		if e.ValueFrom != nil {
+			v, err := e.ValueFrom.read(ctx, coalesce.String(s.Dir, "."))
+			if err != nil {
+				return nil, fmt.Errorf("env %s: %w", e.Name, err)
+			}
+			values[e.Name] = v
+			redact.Add(v)
+		} else if e.Secret && e.Value != nil {
+			redact.Add(*e.Value)
+		}
+	}
+	return values, nil
+}
@@ -5,18 +5,29 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"gopkg.in/yaml.v3"
+
 	"github.com/mertenvg/blade/pkg/coalesce"
+	"github.com/mertenvg/blade/pkg/dedupe"
 
+	"github.com/mertenvg/blade/internal/service/dotenv"
+	"github.com/mertenvg/blade/internal/service/limits"
+	"github.com/mertenvg/blade/internal/service/proc"
+	"github.com/mertenvg/blade/internal/service/pty"
 	"github.com/mertenvg/blade/internal/service/watcher"
 	"github.com/mertenvg/blade/pkg/colorterm"
+	"github.com/mertenvg/blade/pkg/redact"
 )
 
 // empty is used for signal-only channels in place of struct{}{}.
@@ -26,9 +37,43 @@ type empty struct{}
 // cancelling or restarting a running child process.
 const gracePeriod = 5 * time.Second
 
+// memoryCheckInterval is how often the RSS of a service with a soft memory
+// limit is compared against the threshold.
+const memoryCheckInterval = 2 * time.Second
+
 type EnvValue struct {
-	Name  string  `yaml:"name"`
-	Value *string `yaml:"value,omitempty"`
+	Name      string     `yaml:"name"`
+	Value     *string    `yaml:"value,omitempty"`
+	ValueFrom *ValueFrom `yaml:"valueFrom,omitempty"`
+	Secret    bool       `yaml:"secret,omitempty"`
+
+	// Origin describes where the value came from, for `blade config`.
+	Origin string `yaml:"-"`
+}
+
+// Parents are the services a service inherits from, in merge order: later
+// parents override earlier ones. A single parent may be written as a plain
+// string.
+type Parents []string
+
+func (p *Parents) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var name string
+		if err := node.Decode(&name); err != nil {
+			return err
+		}
+		*p = nil
+		if name != "" {
+			*p = Parents{name}
+		}
+		return nil
+	}
+	var names []string
+	if err := node.Decode(&names); err != nil {
+		return err
+	}
+	*p = names
+	return nil
 }
 
 type Output struct {
@@ -48,17 +93,46 @@ func (o Output) InheritFrom(parent Output) Output {
 type S struct {
 	wg        sync.WaitGroup
 	restartCh chan empty
-	startedAt time.Time
 	backoff   time.Duration
-	state string
-	pid   int
+	// statusMu guards pid, state and startedAt, which status requests read
+	// while the service runs
+	statusMu  sync.Mutex
+	pid       int
+	state     string
+	startedAt time.Time
+	cgroup    *limits.Cgroup
+	attrs     procAttrs
+	inputMu   sync.Mutex
+	input     io.Writer
+	writeMu   sync.Mutex
+	// secrets are the valueFrom values read for the current start
+	secrets *secretValues
+	// socketFiles are the listeners of Sockets, open while the service runs,
+	// bound to socketAddrs
+	socketFiles []*os.File
+	socketAddrs []net.Addr
+	// awake is set while a lazy service is started, idleCh signals it has
+	// been idle for IdleTimeout
+	awake  bool
+	idleCh chan empty
+	// dependencies are the jobs to wait for before starting. done is closed
+	// once a job has ended, with its result
+	dependencies []*S
+	jobMu        sync.Mutex
+	done         chan empty
+	result       JobResult
 
 	Name       string     `yaml:"name"`
-	From       string     `yaml:"from"`
+	Type       string     `yaml:"type"`
+	Abstract   bool       `yaml:"abstract"`
+	From       Parents    `yaml:"from"`
 	Tags       []string   `yaml:"tags"`
 	Watch      *watcher.W `yaml:"watch"`
 	InheritEnv bool       `yaml:"inheritEnv"`
+	EnvFile    []string   `yaml:"envFile"`
 	Env        []EnvValue `yaml:"env"`
+	Ports      []Port     `yaml:"ports"`
+	Sockets    []Socket   `yaml:"sockets"`
 	Once       string     `yaml:"once"`
 	Before     string     `yaml:"before"`
 	Run        string     `yaml:"run"`
@@ -67,15 +141,78 @@ type S struct {
 	Dir        string     `yaml:"dir"`
 	Output     Output     `yaml:"output"`
 	Sleep      int        `yaml:"sleep"`
+	Limits     *limits.L  `yaml:"limits"`
+	User       string     `yaml:"user"`
+	Group      string     `yaml:"group"`
+	Umask      string     `yaml:"umask"`
+	Nice       int        `yaml:"nice"`
+	IONice     string     `yaml:"ionice"`
+	TTY        bool       `yaml:"tty"`
+
+	// RestartStrategy is how a running service is restarted, RestartStop
+	// unless set.
+	RestartStrategy string     `yaml:"restartStrategy"`
+	Readiness       *Readiness `yaml:"readiness"`
+	// Lazy services start on the first connection to one of their sockets
+	// instead of with blade, and stop again after IdleTimeout seconds without
+	// connections when it is set.
+	Lazy        bool `yaml:"lazy"`
+	IdleTimeout int  `yaml:"idleTimeout"`
+	// Replicas runs several instances of the service, see ReplicaSet.
+	Replicas int `yaml:"replicas"`
+	// DependsOn names the jobs, or tags of jobs, that have to complete
+	// before the service starts.
+	DependsOn []string `yaml:"dependsOn"`
+
+	// Source is where the service is defined in the configuration.
+	Source Source `yaml:"-"`
+	// Profiles lists the active profiles that changed the service.
+	Profiles []string `yaml:"-"`
+	// ReplicaOf is the name of the service this is a replica of.
+	ReplicaOf string `yaml:"-"`
+	// KillConflicts stops processes holding the ports of the service before
+	// it starts, instead of failing the start.
+	KillConflicts bool `yaml:"-"`
+}
+
+// Source is a position in a configuration file.
+type Source struct {
+	File string
+	Line int
+}
+
+func (s Source) String() string {
+	if s.Line == 0 {
+		return s.File
+	}
+	return fmt.Sprintf("%s:%d", s.File, s.Line)
 }
 
 func (s *S) Start(ctx context.Context) {
 	colorterm.Info(s.Name, "starting")
+	if s.Limits.NeedsCgroup() {
+		cg, err := limits.NewCgroup(s.Name, s.Limits)
+		if err != nil {
+			colorterm.Warning(s.Name, "couldn't create cgroup, memory falls back to setrlimit and cpu is not limited:", err)
+		}
+		s.cgroup = cg
+	}
 	if err := s.run(ctx, s.Once); err != nil {
 		fmt.Println(s.Name, "'once' cmd failed with error:", err)
+		if s.IsJob() {
+			s.finish(JobResult{Err: fmt.Errorf("'once' cmd failed: %w", err), ExitCode: -1})
+		}
 		return
 	}
 	s.restartCh = make(chan empty, 1)
+	if s.IsJob() {
+		s.runJob(ctx)
+		return
+	}
+	s.idleCh = make(chan empty, 1)
+	if s.Lazy {
+		colorterm.Info(s.Name, "idle until the first connection")
+	}
 	if err := s.start(ctx, s.Run); err != nil {
 		colorterm.Error(s.Name, "failed to start with error:", err)
 		return
@@ -87,12 +224,17 @@ func (s *S) Start(ctx context.Context) {
 
 func (s *S) Wait() {
 	s.wg.Wait()
+	s.cgroup.Close()
 	colorterm.Success(s.Name, "finished")
 }
 
 // Restart asks the running loop to terminate the current child process and
 // start a new one. It is a non-blocking signal; coalesces if already pending.
 func (s *S) Restart() {
+	if s.IsJob() && !s.DNR {
+		colorterm.Info(s.Name, "is a job and runs once, not restarting")
+		return
+	}
 	colorterm.Info(s.Name, "restarting")
 	if s.restartCh == nil {
 		return
@@ -116,27 +258,113 @@ func (s *S) Exit() {
 }
 
 func (s *S) Status() (bool, string, string) {
-	active := false
-	state := "not running"
-	pid := "()"
-	if s.state != "" {
-		state = s.state
-	}
-	if s.pid != 0 {
-		pid = fmt.Sprintf("(%d)", s.pid)
-		p, _ := os.FindProcess(s.pid)
-		if p != nil {
-			err := p.Signal(syscall.Signal(0))
-			if err == nil {
-				state = fmt.Sprintf("OK %s", time.Since(s.startedAt).Round(time.Second).String())
-				active = true
-			}
-			if err != nil {
-				state = err.Error()
-			}
-		}
+	pid, state, startedAt := s.status()
+	active, state := describeStatus(pid, state, startedAt)
+	if pid == 0 {
+		return active, state, "()"
+	}
+	return active, state, fmt.Sprintf("(%d)", pid)
+}
+
+// describeStatus reports whether the command pid is alive, and the state to
+// show for it.
+func describeStatus(pid int, state string, startedAt time.Time) (bool, string) {
+	if state == "" {
+		state = "not running"
+	}
+	if pid == 0 {
+		return false, state
+	}
+	p, _ := os.FindProcess(pid)
+	if p == nil {
+		return false, state
+	}
+	if err := p.Signal(syscall.Signal(0)); err != nil {
+		return false, err.Error()
+	}
+	return true, fmt.Sprintf("OK %s", time.Since(startedAt).Round(time.Second).String())
+}
+
+// status returns the pid of the running command, or 0, the state set while
+// the service waits, and when it was last started.
+func (s *S) status() (int, string, time.Time) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.pid, s.state, s.startedAt
+}
+
+// currentPid returns the pid of the running command, or 0.
+func (s *S) currentPid() int {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.pid
+}
+
+func (s *S) setPid(pid int) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.pid = pid
+}
+
+func (s *S) setState(state string) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.state = state
+}
+
+// setStarted records that the command was started now, and returns when.
+func (s *S) setStarted() time.Time {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.startedAt = time.Now()
+	return s.startedAt
+}
+
+// Snapshot is a point-in-time view of a service including the resource usage
+// of its whole process group.
+type Snapshot struct {
+	Name   string        `json:"name"`
+	Active bool          `json:"active"`
+	State  string        `json:"state"`
+	PID    int           `json:"pid,omitempty"`
+	Uptime time.Duration `json:"uptime,omitempty"`
+	Ports  []Port        `json:"ports,omitempty"`
+	Usage  proc.Usage    `json:"usage"`
+	Tree   *proc.Process `json:"tree,omitempty"`
+}
+
+// Snapshot extends Status with CPU time, RSS, thread and fd counts read for
+// every process in the service's tree. `go run` style commands mean the real
+// server is usually a grandchild, so the totals cover all descendants.
+func (s *S) Snapshot() Snapshot {
+	pid, state, startedAt := s.status()
+	active, state := describeStatus(pid, state, startedAt)
+	snap := Snapshot{
+		Name:   s.Name,
+		Active: active,
+		State:  state,
+		Ports:  s.Ports,
+	}
+	if !active {
+		return snap
+	}
+	snap.PID = pid
+	snap.Uptime = time.Since(startedAt)
+	if tree, err := proc.Tree(pid); err == nil {
+		maskCommands(tree)
+		snap.Tree = tree
+		snap.Usage = tree.Total()
+	}
+	return snap
+}
+
+// maskCommands masks secrets passed as arguments in the command lines of a
+// process tree before it is shown by ps or top.
+func maskCommands(p *proc.Process) {
+	p.Command = redact.String(p.Command)
+	for _, child := range p.Children {
+		maskCommands(child)
 	}
-	return active, state, pid
 }
 
 func (s *S) InheritFrom(parent *S) {
@@ -146,25 +374,159 @@ func (s *S) InheritFrom(parent *S) {
 	tags := make([]string, 0, len(parent.Tags)+len(s.Tags)) // []string   `yaml:"tags"`
 	tags = append(tags, parent.Tags...)
 	tags = append(tags, s.Tags...)
-	s.Tags = tags
+	s.Tags = dedupe.StringSlice(tags)
+
+	dependsOn := make([]string, 0, len(parent.DependsOn)+len(s.DependsOn)) // []string   `yaml:"dependsOn"`
+	dependsOn = append(dependsOn, parent.DependsOn...)
+	dependsOn = append(dependsOn, s.DependsOn...)
+	s.DependsOn = dedupe.StringSlice(dependsOn)
+
+	envFile := make([]string, 0, len(parent.EnvFile)+len(s.EnvFile)) // []string   `yaml:"envFile"`
+	envFile = append(envFile, parent.EnvFile...)
+	envFile = append(envFile, s.EnvFile...)
+	s.EnvFile = dedupe.StringSlice(envFile)
 
 	env := make([]EnvValue, 0, len(parent.Env)+len(s.Env)) // []EnvValue `yaml:"env"`
 	env = append(env, parent.Env...)
 	env = append(env, s.Env...)
 	s.Env = env
 
+	s.Ports = inheritPorts(s.Ports, parent.Ports)         // []Port     `yaml:"ports"`
+	s.Sockets = inheritSockets(s.Sockets, parent.Sockets) // []Socket   `yaml:"sockets"`
+
+	s.Type = coalesce.String(parent.Type, s.Type)       // string     `yaml:"type"`
 	s.Once = coalesce.String(parent.Once, s.Once)       // string     `yaml:"once"`
 	s.Before = coalesce.String(parent.Before, s.Before) // string     `yaml:"before"`
 	s.Run = coalesce.String(parent.Run, s.Run)          // string     `yaml:"run"`
 	s.Dir = coalesce.String(parent.Dir, s.Dir)          // string     `yaml:"dir"`
 	s.Sleep = coalesce.Int(parent.Sleep, s.Sleep)       // int        `yaml:"sleep"`
+	s.User = coalesce.String(parent.User, s.User)       // string     `yaml:"user"`
+	s.Group = coalesce.String(parent.Group, s.Group)    // string     `yaml:"group"`
+	s.Umask = coalesce.String(parent.Umask, s.Umask)    // string     `yaml:"umask"`
+	s.Nice = coalesce.Int(parent.Nice, s.Nice)          // int        `yaml:"nice"`
+	s.IONice = coalesce.String(parent.IONice, s.IONice) // string     `yaml:"ionice"`
+
+	s.RestartStrategy = coalesce.String(parent.RestartStrategy, s.RestartStrategy) // string     `yaml:"restartStrategy"`
+	s.IdleTimeout = coalesce.Int(parent.IdleTimeout, s.IdleTimeout)                // int        `yaml:"idleTimeout"`
+	s.Replicas = coalesce.Int(parent.Replicas, s.Replicas)                         // int        `yaml:"replicas"`
 
 	s.InheritEnv = parent.InheritEnv || s.InheritEnv // bool       `yaml:"inheritEnv"`
+	s.TTY = parent.TTY || s.TTY                      // bool       `yaml:"tty"`
 	s.DNR = parent.DNR || s.DNR                      // bool       `yaml:"dnr"`
 	s.Skip = parent.Skip || s.Skip                   // bool       `yaml:"skip"`
+	s.Lazy = parent.Lazy || s.Lazy                   // bool       `yaml:"lazy"`
+	// Abstract is not inherited: a service using a template is a real one
 
 	s.Output = s.Output.InheritFrom(parent.Output)
 	s.Watch = s.Watch.InheritFrom(parent.Watch)
+	s.Limits = s.Limits.InheritFrom(parent.Limits)
+	s.Readiness = s.Readiness.InheritFrom(parent.Readiness)
+}
+
+// Overlay merges o over s, the other way around from InheritFrom: values set
+// in o replace those of s, lists of o are appended to those of s, so env and
+// ports set in o win, and booleans are true if set on either.
+func (s *S) Overlay(o *S) {
+	s.Tags = dedupe.StringSlice(append(slices.Clone(s.Tags), o.Tags...))
+	s.DependsOn = dedupe.StringSlice(append(slices.Clone(s.DependsOn), o.DependsOn...))
+	s.EnvFile = dedupe.StringSlice(append(slices.Clone(s.EnvFile), o.EnvFile...))
+	s.Env = append(slices.Clone(s.Env), o.Env...)
+
+	s.Ports = inheritPorts(o.Ports, s.Ports)
+	s.Sockets = inheritSockets(o.Sockets, s.Sockets)
+
+	s.Type = coalesce.String(o.Type, s.Type)
+	s.Once = coalesce.String(o.Once, s.Once)
+	s.Before = coalesce.String(o.Before, s.Before)
+	s.Run = coalesce.String(o.Run, s.Run)
+	s.Dir = coalesce.String(o.Dir, s.Dir)
+	s.Sleep = coalesce.Int(o.Sleep, s.Sleep)
+	s.User = coalesce.String(o.User, s.User)
+	s.Group = coalesce.String(o.Group, s.Group)
+	s.Umask = coalesce.String(o.Umask, s.Umask)
+	s.Nice = coalesce.Int(o.Nice, s.Nice)
+	s.IONice = coalesce.String(o.IONice, s.IONice)
+
+	s.RestartStrategy = coalesce.String(o.RestartStrategy, s.RestartStrategy)
+	s.IdleTimeout = coalesce.Int(o.IdleTimeout, s.IdleTimeout)
+	s.Replicas = coalesce.Int(o.Replicas, s.Replicas)
+
+	s.InheritEnv = s.InheritEnv || o.InheritEnv
+	s.TTY = s.TTY || o.TTY
+	s.DNR = s.DNR || o.DNR
+	s.Skip = s.Skip || o.Skip
+	s.Lazy = s.Lazy || o.Lazy
+
+	s.Output = s.Output.InheritFrom(o.Output)
+	switch {
+	case o.Watch == nil:
+	case s.Watch == nil || s.Watch.FS == nil:
+		s.Watch = o.Watch
+	default:
+		s.Watch = s.Watch.InheritFrom(o.Watch)
+	}
+	s.Limits = s.Limits.InheritFrom(o.Limits)
+	s.Readiness = s.Readiness.InheritFrom(o.Readiness)
+}
+
+// Validate checks the service configuration for errors that would otherwise
+// only surface when the service is started.
+func (s *S) Validate() error {
+	for _, e := range s.Env {
+		if err := e.validate(); err != nil {
+			return err
+		}
+	}
+	for i, p := range s.Ports {
+		if p.Name == "" {
+			return errors.New("ports: every port needs a name")
+		}
+		if hasPort(s.Ports[:i], p.Name) {
+			return fmt.Errorf("ports: '%s' is declared twice", p.Name)
+		}
+		if p.Port < 0 || p.Port > 65535 {
+			return fmt.Errorf("ports: %s: %d is out of range", p.Name, p.Port)
+		}
+	}
+	if err := validateSockets(s.Sockets); err != nil {
+		return err
+	}
+	switch s.RestartStrategy {
+	case "", RestartStop:
+	case RestartReplace:
+		if s.Readiness == nil {
+			return errors.New("restartStrategy: replace needs a readiness probe to know when the new instance can take over")
+		}
+	default:
+		return fmt.Errorf("restartStrategy: unknown strategy '%s', use %s or %s", s.RestartStrategy, RestartStop, RestartReplace)
+	}
+	if s.Readiness != nil {
+		if err := s.Readiness.validate(); err != nil {
+			return err
+		}
+		// blade holds the sockets, so their backlog or the old instance
+		// answers a probe before the new instance is ready
+		if len(s.Sockets) > 0 && !s.Readiness.Notify {
+			return errors.New("readiness: with sockets only notify tells when the new instance is ready, as tcp and http probes are answered by the old one")
+		}
+	}
+	if err := s.validateLazy(); err != nil {
+		return err
+	}
+	if err := s.validateReplicas(); err != nil {
+		return err
+	}
+	if err := s.validateType(); err != nil {
+		return err
+	}
+	if err := s.Limits.Validate(); err != nil {
+		return err
+	}
+	if err := validateStdin(s.Output.StdinMode()); err != nil {
+		return err
+	}
+	_, err := s.resolveProcAttrs()
+	return err
 }
 
 func (s *S) start(ctx context.Context, cmd string) error {
@@ -176,11 +538,46 @@ func (s *S) start(ctx context.Context, cmd string) error {
 
 	go func() {
 		defer s.wg.Done()
+		defer s.closeSockets()
 
-		for {
+		if err := s.waitDependencies(ctx); err != nil {
+			if ctx.Err() == nil {
+				colorterm.Error(s.Name, "not started:", err)
+			}
+			return
+		}
+
+		for restarted := false; ; restarted = true {
 			if ctx.Err() != nil || s.DNR {
 				return
 			}
+			if restarted {
+				// the first start shares the secrets read for 'once'
+				s.forgetSecrets()
+			}
+
+			if s.Lazy && !s.awake {
+				conns, err := s.waitWake(ctx)
+				if err != nil {
+					colorterm.Error(s.Name, "command failed with error:", err)
+
+					if s.backoff == 0 {
+						s.backoff = time.Second
+					}
+					if !sleepCtx(ctx, s.backoff) {
+						return
+					}
+					s.backoff *= 2
+
+					continue
+				}
+				if ctx.Err() != nil || s.DNR {
+					return
+				}
+				colorterm.Info(s.Name, "connection received, starting")
+				s.awake = true
+				s.handOver(conns)
+			}
 
 			if err := s.run(ctx, s.Before); err != nil {
 				colorterm.Error(s.Name, "'before' cmd failed with error:", err)
@@ -200,15 +597,10 @@ func (s *S) start(ctx context.Context, cmd string) error {
 				continue
 			}
 
-			cmdCtx, cmdCancel := context.WithCancel(ctx)
-			c, closeOutputs := s.parse(cmdCtx, cmd)
-
-			s.startedAt = time.Now()
-
-			if err := c.Start(); err != nil {
-				closeOutputs()
-				cmdCancel()
+			s.setStarted()
 
+			in, err := s.launch(ctx, cmd, false)
+			if err != nil {
 				colorterm.Error(s.Name, "command failed with error:", err)
 
 				if s.DNR || ctx.Err() != nil {
@@ -226,19 +618,22 @@ func (s *S) start(ctx context.Context, cmd string) error {
 				continue
 			}
 
-			s.pid = c.Process.Pid
-			colorterm.Success(s.Name, "running", fmt.Sprintf("(pid:%d)", s.pid))
+			pid := in.c.Process.Pid
+			s.setPid(pid)
+			colorterm.Success(s.Name, "running", fmt.Sprintf("(pid:%d)", pid))
 
-			restartRequested := s.waitCmd(ctx, c)
+			go s.watchMemory(in.ctx, pid)
+			go s.watchIdle(in.ctx, s.socketPorts())
 
-			closeOutputs()
-			cmdCancel()
+			in, restartRequested := s.waitCmd(ctx, cmd, in)
 
-			s.waitForExit(ctx)
-			s.pid = 0
+			in.release()
+
+			s.waitForExit(ctx, s.currentPid())
+			s.setPid(0)
 
 			// Reset backoff if the process ran long enough (not a crash loop)
-			if time.Since(s.startedAt) > s.backoff {
+			if _, _, startedAt := s.status(); time.Since(startedAt) > s.backoff {
 				s.backoff = 0
 			}
 
@@ -273,45 +668,203 @@ func (s *S) start(ctx context.Context, cmd string) error {
 	return nil
 }
 
-// waitCmd waits for c to exit, ctx to be cancelled, or a restart to be
-// requested. On cancel/restart it escalates SIGTERM -> SIGKILL with a grace
-// period and always reaps the child before returning. Returns true if a
-// restart was explicitly requested.
-func (s *S) waitCmd(ctx context.Context, c *exec.Cmd) bool {
-	done := make(chan error, 1)
-	go func() { done <- c.Wait() }()
+// instance is a started run command of the service.
+type instance struct {
+	c            *exec.Cmd
+	ctx          context.Context
+	cancel       context.CancelFunc
+	closeOutputs func()
+	notify       *notifySocket
+
+	// done is closed once c has been reaped, with its result in err
+	done chan empty
+	err  error
+	// notified is closed when the instance reports READY=1
+	notified chan empty
+}
 
-	var restart bool
-	select {
-	case err := <-done:
-		s.logWaitError(err)
-		return false
-	case <-ctx.Done():
-	case <-s.restartCh:
-		restart = true
+// release closes the outputs of the instance and cancels its context once it
+// has exited.
+func (in *instance) release() {
+	in.closeOutputs()
+	in.cancel()
+	if in.notify != nil {
+		in.notify.Close()
+	}
+}
+
+// launch starts cmd as a new instance of the service. A replacement skips the
+// port check, as the instance it replaces still holds the ports.
+func (s *S) launch(ctx context.Context, cmd string, replacement bool) (*instance, error) {
+	cmdCtx, cmdCancel := context.WithCancel(ctx)
+	c, closeOutputs := s.parse(cmdCtx, cmd)
+	in := &instance{c: c, ctx: cmdCtx, cancel: cmdCancel, closeOutputs: closeOutputs, done: make(chan empty)}
+
+	err := s.openSockets()
+	if err == nil {
+		s.passSockets(c)
+		if !replacement {
+			err = s.checkPorts(ctx)
+		}
+	}
+	if err == nil && s.Readiness != nil && s.Readiness.Notify {
+		err = s.listenNotify(in)
+	}
+	if err == nil {
+		err = c.Start()
+	}
+	if err != nil {
+		in.release()
+		return nil, err
+	}
+
+	go func() {
+		in.err = c.Wait()
+		close(in.done)
+	}()
+	return in, nil
+}
+
+// listenNotify gives in a socket to report readiness on, in NOTIFY_SOCKET.
+func (s *S) listenNotify(in *instance) error {
+	n, err := listenNotify()
+	if err != nil {
+		return fmt.Errorf("readiness: %w", err)
+	}
+	in.notify = n
+	in.notified = make(chan empty)
+	in.c.Env = append(in.c.Environ(), "NOTIFY_SOCKET="+n.path())
+	go n.receive(in.notified)
+	return nil
+}
+
+// waitCmd waits for in to exit, ctx to be cancelled, or a restart to be
+// requested. On cancel/restart it stops the instance and always reaps the
+// child before returning, unless the restart strategy replaces it with a new
+// instance, which is then waited for instead. Returns the last instance and
+// true if a restart was explicitly requested.
+func (s *S) waitCmd(ctx context.Context, cmd string, in *instance) (*instance, bool) {
+	for {
+		select {
+		case <-in.done:
+			s.logWaitError(in.err)
+			return in, false
+		case <-ctx.Done():
+			s.stop(in)
+			return in, false
+		case <-s.idleCh:
+			colorterm.Info(s.Name, fmt.Sprintf("no connections for %ds, stopping until the next one", s.IdleTimeout))
+			s.stop(in)
+			s.awake = false
+			return in, true
+		case <-s.restartCh:
+		}
+
+		if s.RestartStrategy == RestartReplace && !s.DNR {
+			if next := s.replace(ctx, cmd, in); next != nil {
+				in = next
+				continue
+			}
+		}
+		s.stop(in)
+		return in, true
 	}
+}
+
+// replace starts a new instance next to old and stops old once the new one is
+// ready. It returns nil, leaving old running, when the new instance fails to
+// start or doesn't become ready, so the caller falls back to stop-then-start.
+func (s *S) replace(ctx context.Context, cmd string, old *instance) *instance {
+	colorterm.Info(s.Name, "starting replacement")
+	s.forgetSecrets()
+	if err := s.run(ctx, s.Before); err != nil {
+		colorterm.Warning(s.Name, "'before' cmd failed, falling back to stop-then-start:", err)
+		return nil
+	}
+	next, err := s.launch(ctx, cmd, true)
+	if err != nil {
+		colorterm.Warning(s.Name, "replacement failed to start, falling back to stop-then-start:", err)
+		return nil
+	}
+	pid := next.c.Process.Pid
+	if err := s.waitReady(ctx, next); err != nil {
+		if s.DNR || ctx.Err() != nil {
+			colorterm.Info(s.Name, fmt.Sprintf("stopping replacement (pid:%d)", pid))
+		} else {
+			colorterm.Warning(s.Name, fmt.Sprintf("replacement (pid:%d) failed readiness, falling back to stop-then-start:", pid), err)
+		}
+		s.stop(next)
+		next.release()
+		s.waitForExit(ctx, pid)
+		return nil
+	}
+
+	oldPid := s.currentPid()
+	s.setPid(pid)
+	s.setStarted()
+	colorterm.Success(s.Name, "running", fmt.Sprintf("(pid:%d)", pid), fmt.Sprintf("replacing pid:%d", oldPid))
+	go s.watchMemory(next.ctx, pid)
+	go s.watchIdle(next.ctx, s.socketPorts())
+
+	s.stop(old)
+	old.release()
+	s.waitForExit(ctx, oldPid)
+	return next
+}
+
+// stop terminates in, escalating SIGTERM -> SIGKILL with a grace period, and
+// waits for the child to be reaped.
+func (s *S) stop(in *instance) {
+	pid := in.c.Process.Pid
 
 	// Graceful termination — signal the entire process group so that
 	// grandchildren (e.g. the actual server spawned by `go run`) also
 	// receive the signal and release their sockets.
-	_ = s.signalGroup(syscall.SIGTERM)
+	_ = signalGroup(pid, syscall.SIGTERM)
 	select {
-	case err := <-done:
-		s.logWaitError(err)
-		return restart
+	case <-in.done:
+		s.logWaitError(in.err)
+		return
 	case <-time.After(gracePeriod):
 	}
 
 	// Force kill the entire process group.
 	colorterm.Warning(s.Name, "process did not exit after SIGTERM, sending SIGKILL")
-	_ = s.signalGroup(syscall.SIGKILL)
+	_ = signalGroup(pid, syscall.SIGKILL)
 	select {
-	case err := <-done:
-		s.logWaitError(err)
+	case <-in.done:
+		s.logWaitError(in.err)
 	case <-time.After(gracePeriod):
 		colorterm.Error(s.Name, "process did not exit after SIGKILL; abandoning")
 	}
-	return restart
+}
+
+// watchMemory restarts the service once the RSS of its process tree exceeds
+// limits.softMemory. It returns when ctx is cancelled or after requesting a
+// restart.
+func (s *S) watchMemory(ctx context.Context, pid int) {
+	soft := s.Limits.SoftMemoryBytes()
+	if soft == 0 {
+		return
+	}
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		tree, err := proc.Tree(pid)
+		if err != nil {
+			continue
+		}
+		if rss := tree.Total().RSS; rss > soft {
+			colorterm.Warningf("%s rss %d bytes exceeds softMemory %s", s.Name, rss, s.Limits.SoftMemory)
+			s.Restart()
+			return
+		}
+	}
 }
 
 func (s *S) logWaitError(err error) {
@@ -339,7 +892,10 @@ func (s *S) run(ctx context.Context, cmd string) error {
 	c, closeOutputs := s.parse(ctx, cmd)
 	defer closeOutputs()
 
-	return c.Run()
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
 }
 
 // sleepCtx sleeps for d or until ctx is cancelled. Returns false if ctx was
@@ -387,6 +943,7 @@ func (s *S) parse(ctx context.Context, cmd string) (*exec.Cmd, func()) {
 	c := exec.CommandContext(ctx, name, args...)
 	c.Dir = coalesce.String(s.Dir, ".")
 	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	s.cgroup.Apply(c.SysProcAttr)
 	c.Cancel = func() error {
 		return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
 	}
@@ -394,6 +951,13 @@ func (s *S) parse(ctx context.Context, cmd string) (*exec.Cmd, func()) {
 
 	var closers []func()
 
+	// secrets are read before any output is set up, so they are masked
+	// from the first byte the service writes
+	secrets, err := s.startSecrets(ctx)
+	if err != nil && c.Err == nil {
+		c.Err = err
+	}
+
 	if w, closer, err := s.resolveWriter(s.Output.Stdout, os.Stdout); err != nil {
 		colorterm.Error(s.Name, "stdout:", err)
 	} else {
@@ -412,17 +976,63 @@ func (s *S) parse(ctx context.Context, cmd string) (*exec.Cmd, func()) {
 		}
 	}
 
-	if s.Output.Stdin != "os" {
-		c.Stdin = os.Stdin
+	// a secret of one service may be printed by another, e.g. a database
+	// logging its DSN, so every service is masked once there are secrets
+	if redact.Active() {
+		if c.Stdout != nil {
+			w := redact.NewWriter(c.Stdout)
+			c.Stdout = w
+			closers = append(closers, func() { w.Flush() })
+		}
+		if c.Stderr != nil {
+			w := redact.NewWriter(c.Stderr)
+			c.Stderr = w
+			closers = append(closers, func() { w.Flush() })
+		}
+	}
+
+	var term *os.File
+	if s.TTY {
+		if master, closer, err := s.attachPTY(c); err != nil {
+			colorterm.Warning(s.Name, "couldn't allocate a tty, falling back to pipes:", err)
+		} else {
+			closers = append(closers, closer)
+			term = master
+		}
+	}
+
+	if closer, err := s.resolveStdin(c, term); err != nil {
+		colorterm.Error(s.Name, "stdin:", err)
+	} else if closer != nil {
+		closers = append(closers, closer)
 	}
 
 	if !s.InheritEnv {
 		c.Env = []string{}
 	}
 
+	attrs, err := s.resolveProcAttrs()
+	if err != nil && c.Err == nil {
+		c.Err = err
+	}
+	s.attrs = attrs
+	s.applyProcAttrs(c)
+
+	// env files are read on every start so edits apply on restart; a file
+	// that can't be read fails the start like a missing executable would
+	vars, err := s.loadEnvFiles()
+	if err != nil && c.Err == nil {
+		c.Err = fmt.Errorf("envFile: %w", err)
+	}
+	for _, v := range vars {
+		c.Env = append(c.Environ(), fmt.Sprintf("%s=%s", v.Name, v.Value))
+	}
+
 	for _, e := range s.Env {
 		v := os.Getenv(e.Name)
-		if e.Value != nil {
+		if e.ValueFrom != nil {
+			v = secrets[e.Name]
+		} else if e.Value != nil {
 			v = *e.Value
 		}
 		c.Env = append(c.Environ(), fmt.Sprintf("%s=%s", e.Name, v))
@@ -431,8 +1041,9 @@ func (s *S) parse(ctx context.Context, cmd string) (*exec.Cmd, func()) {
 	var once sync.Once
 	closeOutputs := func() {
 		once.Do(func() {
-			for _, close := range closers {
-				close()
+			// in reverse, so a pty is drained before the sinks it writes to close
+			for i := len(closers) - 1; i >= 0; i-- {
+				closers[i]()
 			}
 		})
 	}
@@ -440,32 +1051,101 @@ func (s *S) parse(ctx context.Context, cmd string) (*exec.Cmd, func()) {
 	return c, closeOutputs
 }
 
-// signalGroup sends a signal to the entire process group of the running child.
+// loadEnvFiles reads the service's env files in order, relative to its
+// directory. Files with a '?' suffix are skipped when they don't exist.
+// References in a file resolve against the files before it, then the
+// environment blade was started with.
+func (s *S) loadEnvFiles() ([]dotenv.Var, error) {
+	var vars []dotenv.Var
+	defined := make(map[string]string)
+	lookup := func(name string) (string, bool) {
+		if v, ok := defined[name]; ok {
+			return v, true
+		}
+		return os.LookupEnv(name)
+	}
+	for _, file := range s.EnvFile {
+		path, optional := strings.CutSuffix(file, "?")
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(s.Dir, path)
+		}
+		loaded, err := dotenv.Load(path, lookup)
+		if optional && errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range loaded {
+			defined[v.Name] = v.Value
+		}
+		vars = append(vars, loaded...)
+	}
+	return vars, nil
+}
+
+// attachPTY connects the command's stdio to a new pseudo-terminal and copies
+// everything written to it into the command's stdout sink, so tools that
+// check isatty keep their colours and progress output. The returned function
+// releases the pty once the command has exited.
+func (s *S) attachPTY(c *exec.Cmd) (*os.File, func(), error) {
+	master, slave, err := pty.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := c.Stdout
+	if out == nil {
+		out = io.Discard
+	}
+	c.Stdin, c.Stdout, c.Stderr = slave, slave, slave
+	pty.Controlling(c.SysProcAttr)
+
+	stopResize := pty.Follow(master)
+	copied := make(chan empty)
+	go func() {
+		// ends with EIO once every holder of the slave end has closed it
+		_, _ = io.Copy(out, master)
+		close(copied)
+	}()
+
+	return master, func() {
+		stopResize()
+		slave.Close()
+		select {
+		case <-copied:
+		case <-time.After(time.Second):
+		}
+		master.Close()
+	}, nil
+}
+
+// signalGroup sends a signal to the entire process group of the child pid.
 // This ensures grandchildren (e.g. a server spawned by `go run`) also receive
 // the signal and release their resources (sockets, files, etc.).
-func (s *S) signalGroup(sig syscall.Signal) error {
-	if s.pid <= 0 {
+func signalGroup(pid int, sig syscall.Signal) error {
+	if pid <= 0 {
 		return nil
 	}
-	return syscall.Kill(-s.pid, sig)
+	return syscall.Kill(-pid, sig)
 }
 
-// waitForExit polls until the process group is gone or a 5s deadline is hit.
-// After waitCmd has sent SIGTERM/SIGKILL to the group and c.Wait() has reaped
-// the direct child, resources (sockets, files) are already released. This poll
-// is a safety net; zombies may linger but don't hold resources.
-func (s *S) waitForExit(ctx context.Context) {
+// waitForExit polls until the process group of pid is gone or a 5s deadline
+// is hit. After stop has sent SIGTERM/SIGKILL to the group and c.Wait() has
+// reaped the direct child, resources (sockets, files) are already released.
+// This poll is a safety net; zombies may linger but don't hold resources.
+func (s *S) waitForExit(ctx context.Context, pid int) {
 	deadline := time.After(5 * time.Second)
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 
 	for {
-		if s.pid <= 0 || syscall.Kill(-s.pid, 0) != nil {
+		if pid <= 0 || syscall.Kill(-pid, 0) != nil {
 			return
 		}
 		select {
 		case <-ctx.Done():
-			_ = s.signalGroup(syscall.SIGKILL)
+			_ = signalGroup(pid, syscall.SIGKILL)
 			return
 		case <-deadline:
 			return
@@ -10,6 +10,9 @@ import (
 	"testing"
 	"time"
 
+	"gopkg.in/yaml.v3"
+
+	"github.com/mertenvg/blade/internal/service/limits"
 	"github.com/mertenvg/blade/pkg/coalesce"
 )
 
@@ -22,6 +25,157 @@ func TestCoalesce(t *testing.T) {
 	}
 }
 
+func TestParse_EnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("A=file\nB=file\nC=${A}-c\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".env.local"), []byte("B=local\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &S{
+		Name:    "svc",
+		Dir:     dir,
+		EnvFile: []string{".env", ".env.local", ".env.missing?"},
+		Env:     []EnvValue{{Name: "A", Value: strPtr("inline")}},
+	}
+	cmd, closeOutputs := s.parse(context.Background(), "echo hi")
+	defer closeOutputs()
+	if cmd.Err != nil {
+		t.Fatalf("unexpected error %v", cmd.Err)
+	}
+	env := make(map[string]string)
+	for _, kv := range cmd.Env {
+		name, value, _ := strings.Cut(kv, "=")
+		env[name] = value // later entries win, as they do for exec
+	}
+	if env["A"] != "inline" || env["B"] != "local" || env["C"] != "file-c" {
+		t.Errorf("unexpected env %v", env)
+	}
+
+	s.EnvFile = append(s.EnvFile, ".env.required")
+	cmd, closeMissing := s.parse(context.Background(), "echo hi")
+	defer closeMissing()
+	if cmd.Err == nil || !strings.Contains(cmd.Err.Error(), "envFile: open ") {
+		t.Errorf("expected a missing env file to fail the start, got %v", cmd.Err)
+	}
+}
+
+func TestParse_Secrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("file-s3cret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &S{
+		Name: "svc",
+		Dir:  dir,
+		Env: []EnvValue{
+			{Name: "TOKEN", ValueFrom: &ValueFrom{File: "token"}},
+			{Name: "API_KEY", ValueFrom: &ValueFrom{Command: "echo cmd-s3cret"}},
+			{Name: "PASSWORD", Value: strPtr("inline-s3cret"), Secret: true},
+		},
+		Output: Output{Stdout: "file:" + filepath.Join(dir, "out.log")},
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cmd, closeOutputs := s.parse(context.Background(), "/bin/echo file-s3cret cmd-s3cret inline-s3cret public")
+	assertEnvHas(t, cmd.Env, "TOKEN=file-s3cret")
+	assertEnvHas(t, cmd.Env, "API_KEY=cmd-s3cret")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	closeOutputs()
+
+	out, err := os.ReadFile(filepath.Join(dir, "out.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out); got != "**** **** **** public\n" {
+		t.Errorf("expected secrets to be masked in the output, got %q", got)
+	}
+
+	s.Env[0].ValueFrom = &ValueFrom{File: "missing"}
+	s.forgetSecrets()
+	cmd, closeMissing := s.parse(context.Background(), "echo hi")
+	defer closeMissing()
+	if cmd.Err == nil || !strings.HasPrefix(cmd.Err.Error(), "env TOKEN: open ") {
+		t.Errorf("expected an unreadable secret to fail the start, got %v", cmd.Err)
+	}
+
+	s.Env[0].Value = strPtr("x")
+	if err := s.Validate(); err == nil || err.Error() != "env TOKEN: value and valueFrom can't be used together" {
+		t.Errorf("unexpected validation error %v", err)
+	}
+}
+
+func TestParse_SecretsOfOtherServicesMasked(t *testing.T) {
+	dir := t.TempDir()
+	db := &S{Name: "db", Dir: dir, Env: []EnvValue{{Name: "DSN", ValueFrom: &ValueFrom{Command: "echo postgres://db-s3cret@localhost"}}}}
+	api := &S{Name: "api", Output: Output{Stdout: "file:" + filepath.Join(dir, "api.log")}}
+
+	cmd, closeOutputs := db.parse(context.Background(), "true")
+	closeOutputs()
+	if cmd.Err != nil {
+		t.Fatal(cmd.Err)
+	}
+	cmd, closeOutputs = api.parse(context.Background(), "/bin/echo connecting to postgres://db-s3cret@localhost")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	closeOutputs()
+
+	out, err := os.ReadFile(filepath.Join(dir, "api.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out); got != "connecting to ****\n" {
+		t.Errorf("expected the secret of db to be masked in the output of api, got %q", got)
+	}
+}
+
+func TestStart_SecretsReadOncePerStart(t *testing.T) {
+	dir := t.TempDir()
+	reads, starts := filepath.Join(dir, "reads"), filepath.Join(dir, "starts")
+	s := &S{
+		Name:   "svc",
+		Env:    []EnvValue{{Name: "TOKEN", ValueFrom: &ValueFrom{Command: script(t, "secret", "echo read >> "+reads+"\necho s3cret")}}},
+		Once:   "true",
+		Before: "true",
+		Run:    script(t, "run", "echo start >> "+starts+"\nexec sleep 30"),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+
+	lines := func(path string) int {
+		data, _ := os.ReadFile(path)
+		return strings.Count(string(data), "\n")
+	}
+	waitStarts := func(want int) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); lines(starts) < want; {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d starts, got %d", want, lines(starts))
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	s.Start(ctx)
+	waitStarts(1)
+	if got := lines(reads); got != 1 {
+		t.Errorf("expected once, before and run to share a single read, got %d", got)
+	}
+	s.Restart()
+	waitStarts(2)
+	if got := lines(reads); got != 2 {
+		t.Errorf("expected the restart to read the secret again, got %d reads", got)
+	}
+}
+
 func TestParse_EnvHandling_NoInherit(t *testing.T) {
 	// ensure we have a noisy env var present in the process environment
 	os.Setenv("NOISY_ENV", "present")
@@ -51,10 +205,9 @@ func TestParse_EnvHandling_NoInherit(t *testing.T) {
 	assertEnvHas(t, env, "FOO=bar")
 	assertEnvHasPrefix(t, env, "PATH=")
 
-	// Output.Stdin behavior seems inverted: with default empty Output.Stdin, parse sets c.Stdin to os.Stdin
-	// This may unintentionally allow child processes to read from parent's stdin.
-	if cmd.Stdin == nil {
-		t.Errorf("expected Stdin to be non-nil due to current parse logic; potential security concern: stdin is always wired unless explicitly set to 'os'")
+	// With the default stdin mode (none) the child must not share blade's stdin.
+	if cmd.Stdin != nil {
+		t.Errorf("expected Stdin to be nil by default, got %v", cmd.Stdin)
 	}
 }
 
@@ -275,6 +428,54 @@ func TestInheritFrom_OnceAndBefore(t *testing.T) {
 	}
 }
 
+func TestParents_UnmarshalYAML(t *testing.T) {
+	for in, want := range map[string]int{"from: a": 1, "from: [a, b]": 2, "from: ''": 0, "name: x": 0} {
+		var s S
+		if err := yaml.Unmarshal([]byte(in), &s); err != nil {
+			t.Fatalf("%s: %v", in, err)
+		}
+		if len(s.From) != want {
+			t.Errorf("%s: expected %d parents, got %v", in, want, s.From)
+		}
+	}
+}
+
+func TestInheritFrom_ParentValuesWin(t *testing.T) {
+	parent := &S{Abstract: true, Run: "parent-run", Sleep: 100, User: "nobody", Output: Output{Stdout: "os"}}
+
+	child := &S{Run: "child-run", Dir: "child", Sleep: 5, Output: Output{Stdout: "file:child.log", Stderr: "os"}}
+	child.InheritFrom(parent)
+	if child.Run != "parent-run" || child.Sleep != 100 || child.Output.Stdout != "os" {
+		t.Errorf("values set on the parent should win: got run %q sleep %d stdout %q", child.Run, child.Sleep, child.Output.Stdout)
+	}
+	if child.Abstract {
+		t.Errorf("abstract must not be inherited")
+	}
+	if child.Dir != "child" || child.User != "nobody" || child.Output.Stderr != "os" {
+		t.Errorf("values set on only one of them should be kept: got dir %q user %q stderr %q", child.Dir, child.User, child.Output.Stderr)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	baseVal, overlayVal := "base", "overlay"
+	base := &S{Run: "base-run", Dir: "base", Tags: []string{"a"}, Env: []EnvValue{{Name: "A", Value: &baseVal}}, Skip: true}
+	overlay := &S{Run: "overlay-run", Tags: []string{"b"}, Env: []EnvValue{{Name: "A", Value: &overlayVal}}}
+
+	base.Overlay(overlay)
+	if base.Run != "overlay-run" || base.Dir != "base" || !base.Skip {
+		t.Errorf("values set in the overlay should win over the base: got run %q dir %q skip %v", base.Run, base.Dir, base.Skip)
+	}
+	if len(base.Tags) != 2 || base.Tags[1] != "b" {
+		t.Errorf("expected the overlay tags after the base ones, got %v", base.Tags)
+	}
+	if len(base.Env) != 2 || *base.Env[1].Value != "overlay" {
+		t.Errorf("expected the overlay env last so it wins, got %v", base.Env)
+	}
+	if len(overlay.Env) != 1 {
+		t.Errorf("the overlay must not be changed, got env %v", overlay.Env)
+	}
+}
+
 // TestStart_OnceRunsOnceAndBeforeRunsOnEachStart verifies the lifecycle:
 // `once` fires a single time prior to the first start, while `before` runs
 // every time the service starts — including after a watcher-triggered restart.
@@ -362,6 +563,63 @@ func TestStart_OnceRunsOnceAndBeforeRunsOnEachStart(t *testing.T) {
 	}
 }
 
+// TestStart_SoftMemoryRestartsService verifies that a service whose process
+// tree exceeds limits.softMemory is restarted with a new process.
+func TestStart_SoftMemoryRestartsService(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("rss is read from /proc")
+	}
+	s := &S{Name: "svc", Run: "sleep 30", Limits: &limits.L{SoftMemory: "1K"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+	s.Start(ctx)
+
+	var first int
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		pid := s.currentPid()
+		if first == 0 && pid != 0 {
+			first = pid
+		}
+		if first != 0 && pid != 0 && pid != first {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("service was not restarted after exceeding softMemory (first pid %d)", first)
+}
+
+// TestRun_TTYOutputFlowsToSink verifies that with tty enabled the child sees
+// a terminal on stdout and stderr, and its output still reaches the
+// configured sink.
+func TestRun_TTYOutputFlowsToSink(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("pty is only supported on linux and darwin")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "tty.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n[ -t 1 ] && echo stdout-tty\n[ -t 2 ] && echo stderr-tty >&2\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.log")
+
+	s := &S{Name: "svc", TTY: true, Output: Output{Stdout: "file:" + out}}
+	if err := s.run(context.Background(), "sh "+script); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.ReplaceAll(string(data), "\r", ""); got != "stdout-tty\nstderr-tty\n" {
+		t.Fatalf("unexpected tty output %q", data)
+	}
+}
+
 // helpers
 func strPtr(s string) *string { return &s }
 
@@ -384,3 +642,34 @@ func assertEnvHasPrefix(t *testing.T, env []string, prefix string) {
 	}
 	t.Fatalf("env does not contain prefix %q; got %v", prefix, env)
 }
+
+// TestSnapshot_WhileRestarting reads the status of a service from another
+// goroutine while it restarts, as control requests do; run with -race.
+func TestSnapshot_WhileRestarting(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix sleep")
+	}
+	s := &S{Name: "svc", Run: "sleep 30"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+	s.Start(ctx)
+
+	var first int
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.Status()
+		snap := s.Snapshot()
+		if !snap.Active {
+			continue
+		}
+		if first == 0 {
+			first = snap.PID
+			s.Restart()
+		} else if snap.PID != first {
+			return
+		}
+	}
+	t.Fatalf("service was not seen running again after a restart (first pid %d)", first)
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ListenExecCommand is the hidden blade command that sets LISTEN_PID before
+// replacing itself with a service holding blade's sockets.
+const ListenExecCommand = "__listen-exec"
+
+// ExecShim is the path of the blade binary. When set, services with sockets
+// are started through ListenExecCommand, so that LISTEN_PID holds their pid
+// as the systemd protocol requires, and services with a user, group, umask,
+// nice, ionice or rlimits through ProcExecCommand. Without it LISTEN_PID is
+// left out and those settings fail the start.
+var ExecShim string
+
+// Socket is a listener blade opens for the service and keeps open across
+// restarts, so connections wait in the backlog while the service restarts
+// instead of being refused. It is passed to the service as an inherited fd
+// following the systemd socket activation protocol.
+type Socket struct {
+	Name string `yaml:"name" json:"name"`
+	// Listen is a TCP address like ":8080", or unix:<path>.
+	Listen string `yaml:"listen" json:"listen"`
+}
+
+// Network returns the network and address to listen on.
+func (so Socket) Network() (string, string) {
+	if path, ok := strings.CutPrefix(so.Listen, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", so.Listen
+}
+
+// inheritSockets merges the sockets of parent into sockets, keeping those of
+// the child where both declare the same name.
+func inheritSockets(sockets, parent []Socket) []Socket {
+	merged := make([]Socket, 0, len(parent)+len(sockets))
+	for _, so := range parent {
+		if !slices.ContainsFunc(sockets, func(own Socket) bool { return own.Name == so.Name }) {
+			merged = append(merged, so)
+		}
+	}
+	return append(merged, sockets...)
+}
+
+func validateSockets(sockets []Socket) error {
+	for i, so := range sockets {
+		switch {
+		case so.Name == "":
+			return errors.New("sockets: every socket needs a name")
+		case strings.Contains(so.Name, ":"):
+			return fmt.Errorf("sockets: name '%s' can't contain ':'", so.Name)
+		case so.Listen == "":
+			return fmt.Errorf("sockets: %s: missing listen address", so.Name)
+		case slices.ContainsFunc(sockets[:i], func(prev Socket) bool { return prev.Name == so.Name }):
+			return fmt.Errorf("sockets: '%s' is declared twice", so.Name)
+		}
+	}
+	return nil
+}
+
+// openSockets binds the sockets of the service, unless they are open already
+// from an earlier start.
+func (s *S) openSockets() error {
+	if len(s.Sockets) == 0 || len(s.socketFiles) > 0 {
+		return nil
+	}
+	var files []*os.File
+	var addrs []net.Addr
+	for _, so := range s.Sockets {
+		network, address := so.Network()
+		if network == "unix" {
+			// a socket file left by an earlier run would fail the bind
+			os.Remove(address)
+		}
+		l, err := net.Listen(network, address)
+		if err != nil {
+			closeFiles(files)
+			return fmt.Errorf("socket %s: %w", so.Name, err)
+		}
+		addrs = append(addrs, l.Addr())
+		f, err := l.(interface{ File() (*os.File, error) }).File()
+		// the file is a duplicate, blade only holds on to that one
+		l.Close()
+		if err != nil {
+			closeFiles(files)
+			return fmt.Errorf("socket %s: %w", so.Name, err)
+		}
+		files = append(files, f)
+	}
+	s.socketFiles, s.socketAddrs = files, addrs
+	return nil
+}
+
+// closeSockets releases the sockets once the service is done for good.
+func (s *S) closeSockets() {
+	closeFiles(s.socketFiles)
+	s.socketFiles, s.socketAddrs = nil, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// passSockets hands the open sockets to c as fds 3 and up, described by
+// LISTEN_FDS and LISTEN_FDNAMES.
+func (s *S) passSockets(c *exec.Cmd) {
+	if len(s.socketFiles) == 0 {
+		return
+	}
+	names := make([]string, len(s.Sockets))
+	for i, so := range s.Sockets {
+		names[i] = so.Name
+	}
+	c.ExtraFiles = s.socketFiles
+	c.Env = append(c.Environ(), "LISTEN_FDS="+strconv.Itoa(len(s.socketFiles)), "LISTEN_FDNAMES="+strings.Join(names, ":"))
+	if ExecShim != "" && c.Err == nil {
+		c.Args = append([]string{ExecShim, ListenExecCommand, c.Path}, c.Args...)
+		c.Path = ExecShim
+	}
+}
+
+// ListenExec runs the command in args, a path followed by the arguments
+// including the name it was started as, in place of the current process with
+// LISTEN_PID set to its pid, which stays the same across the exec.
+func ListenExec(args []string) error {
+	if len(args) < 2 {
+		return errors.New("listen-exec: missing command")
+	}
+	env := append(os.Environ(), "LISTEN_PID="+strconv.Itoa(os.Getpid()))
+	return syscall.Exec(args[0], args[1:], env)
+}
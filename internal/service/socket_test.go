@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSockets_PassedToCommand(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "env.log")
+	s := &S{
+		Name:    "web",
+		Sockets: []Socket{{Name: "http", Listen: "127.0.0.1:0"}, {Name: "admin", Listen: "unix:" + filepath.Join(dir, "admin.sock")}},
+		Output:  Output{Stdout: "file:" + out},
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.openSockets(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.closeSockets()
+	first := s.socketFiles[0]
+
+	c, closeOutputs := s.parse(context.Background(), "/bin/sh -c env")
+	s.passSockets(c)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	closeOutputs()
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"LISTEN_FDS=2\n", "LISTEN_FDNAMES=http:admin\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in env:\n%s", want, data)
+		}
+	}
+
+	// a restart reuses the sockets that are already open
+	if err := s.openSockets(); err != nil || s.socketFiles[0] != first {
+		t.Errorf("expected the sockets to stay open across starts, got %v", err)
+	}
+
+	dup := &S{Name: "dup", Sockets: []Socket{{Name: "http", Listen: ":1"}, {Name: "http", Listen: ":2"}}}
+	if err := dup.Validate(); err == nil || err.Error() != "sockets: 'http' is declared twice" {
+		t.Errorf("unexpected validation error %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mertenvg/blade/pkg/colorterm"
+)
+
+// Stdin modes for Output.Stdin.
+const (
+	StdinNone   = "none"   // the child's stdin is empty (default)
+	StdinPipe   = "pipe"   // fed through the control socket, see `blade send`
+	StdinAttach = "attach" // fed from blade's terminal while the service has focus
+	StdinFile   = "file:"  // prefix; the child reads the named file
+)
+
+// ErrNoStdin is returned when writing to a service that doesn't accept input.
+var ErrNoStdin = errors.New("service doesn't accept input")
+
+// StdinMode returns the normalised stdin mode. "os" is accepted as a legacy
+// alias for attach.
+func (o Output) StdinMode() string {
+	switch {
+	case o.Stdin == "" || o.Stdin == StdinNone:
+		return StdinNone
+	case o.Stdin == "os":
+		return StdinAttach
+	default:
+		return o.Stdin
+	}
+}
+
+func validateStdin(mode string) error {
+	switch {
+	case mode == StdinNone, mode == StdinPipe, mode == StdinAttach:
+		return nil
+	case strings.HasPrefix(mode, StdinFile):
+		if strings.TrimPrefix(mode, StdinFile) == "" {
+			return fmt.Errorf("output.stdin: file: requires a path")
+		}
+		return nil
+	}
+	return fmt.Errorf("output.stdin: unknown mode '%s', expected none, pipe, attach or file:<path>", mode)
+}
+
+// stdinWriteTimeout is how long WriteStdin waits for a command that doesn't
+// read its input to make room in the pipe.
+var stdinWriteTimeout = 5 * time.Second
+
+// WriteStdin writes p to the stdin of the command the service is currently
+// running. It fails unless stdin is "pipe" or "attach" and a command is
+// running, or when the command doesn't read its input for stdinWriteTimeout.
+func (s *S) WriteStdin(p []byte) (int, error) {
+	s.inputMu.Lock()
+	w := s.input
+	s.inputMu.Unlock()
+	if w == nil {
+		return 0, ErrNoStdin
+	}
+	// writes are serialised so input from several senders isn't interleaved,
+	// but outside inputMu, so a full pipe doesn't hold up the command's exit
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if f, ok := w.(*os.File); ok {
+		// a pty may not support deadlines, it is written to without one
+		_ = f.SetWriteDeadline(time.Now().Add(stdinWriteTimeout))
+	}
+	n, err := w.Write(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = fmt.Errorf("service isn't reading its input: %w", err)
+	}
+	return n, err
+}
+
+func (s *S) setInput(w io.Writer) {
+	s.inputMu.Lock()
+	defer s.inputMu.Unlock()
+	s.input = w
+}
+
+func (s *S) clearInput(w io.Writer) {
+	s.inputMu.Lock()
+	defer s.inputMu.Unlock()
+	if s.input == w {
+		s.input = nil
+	}
+}
+
+// resolveStdin wires the command's stdin according to the service's mode.
+// When the command runs in a pty its stdin is already the slave end, and term
+// is the master that input is written to instead of a pipe. The returned
+// function, which may be nil, releases the pipe or file.
+func (s *S) resolveStdin(c *exec.Cmd, term *os.File) (func(), error) {
+	mode := s.Output.StdinMode()
+	switch {
+	case mode == StdinPipe || mode == StdinAttach:
+		if term != nil {
+			s.setInput(term)
+			return func() { s.clearInput(term) }, nil
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("create pipe: %w", err)
+		}
+		c.Stdin = r
+		s.setInput(w)
+		return func() {
+			s.clearInput(w)
+			w.Close()
+			r.Close()
+		}, nil
+	case strings.HasPrefix(mode, StdinFile):
+		f, err := os.Open(strings.TrimPrefix(mode, StdinFile))
+		if err != nil {
+			return nil, fmt.Errorf("open file: %w", err)
+		}
+		if term != nil {
+			go func() { _, _ = io.Copy(term, f) }()
+		} else {
+			c.Stdin = f
+		}
+		return func() { f.Close() }, nil
+	}
+	return nil, nil
+}
+
+// StdinRouter forwards blade's own stdin to one attached service at a time,
+// so several interactive services can share a single terminal.
+type StdinRouter struct {
+	mu       sync.Mutex
+	services map[string]*S
+	focus    *S
+}
+
+func NewStdinRouter() *StdinRouter {
+	return &StdinRouter{services: make(map[string]*S)}
+}
+
+// Add registers s with the router if its stdin mode is attach. The first
+// service added receives focus.
+func (r *StdinRouter) Add(s *S) {
+	if s.Output.StdinMode() != StdinAttach {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.services[s.Name] = s
+	if r.focus == nil {
+		r.focus = s
+	}
+}
+
+// Len returns the number of attachable services.
+func (r *StdinRouter) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.services)
+}
+
+// Focus directs the terminal's input to the named service.
+func (r *StdinRouter) Focus(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.services[name]
+	if !ok {
+		return fmt.Errorf("service '%s' doesn't have stdin: attach", name)
+	}
+	r.focus = s
+	return nil
+}
+
+// Focused returns the name of the service receiving input, if any.
+func (r *StdinRouter) Focused() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.focus == nil {
+		return ""
+	}
+	return r.focus.Name
+}
+
+// Run copies from in to the focused service until in is exhausted. Input
+// arriving while the focused service isn't running is dropped.
+func (r *StdinRouter) Run(in io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			r.mu.Lock()
+			s := r.focus
+			r.mu.Unlock()
+			if s != nil {
+				if _, werr := s.WriteStdin(buf[:n]); werr != nil {
+					colorterm.Warning(s.Name, "input dropped:", werr)
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
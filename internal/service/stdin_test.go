@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate_StdinModes(t *testing.T) {
+	for _, mode := range []string{"", "none", "os", "pipe", "attach", "file:in.txt"} {
+		s := &S{Name: "svc", Output: Output{Stdin: mode}}
+		if err := s.Validate(); err != nil {
+			t.Errorf("stdin %q: unexpected error %v", mode, err)
+		}
+	}
+	for _, mode := range []string{"file:", "keyboard"} {
+		s := &S{Name: "svc", Output: Output{Stdin: mode}}
+		if err := s.Validate(); err == nil {
+			t.Errorf("stdin %q: expected validation error", mode)
+		}
+	}
+}
+
+func TestRun_StdinFromFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix cat")
+	}
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	out := filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(in, []byte("from file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &S{Name: "svc", Output: Output{Stdin: "file:" + in, Stdout: "file:" + out}}
+	if err := s.run(context.Background(), "cat"); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "from file\n" {
+		t.Fatalf("unexpected output %q", data)
+	}
+}
+
+func TestStart_StdinPipeAndRouter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix cat")
+	}
+	out := filepath.Join(t.TempDir(), "out.txt")
+	repl := &S{Name: "repl", Run: "cat", Output: Output{Stdin: "attach", Stdout: "file:" + out}}
+	other := &S{Name: "other", Run: "sleep 30"}
+
+	if _, err := repl.WriteStdin([]byte("x")); !errors.Is(err, ErrNoStdin) {
+		t.Fatalf("expected ErrNoStdin before start, got %v", err)
+	}
+
+	router := NewStdinRouter()
+	router.Add(repl)
+	router.Add(other)
+	if router.Len() != 1 || router.Focused() != "repl" {
+		t.Fatalf("expected only repl to be attachable and focused, got %d %q", router.Len(), router.Focused())
+	}
+	if err := router.Focus("other"); err == nil {
+		t.Fatalf("expected focusing a service without stdin: attach to fail")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		repl.Wait()
+	}()
+	repl.Start(ctx)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	go router.Run(r)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := w.Write([]byte("typed\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if data, _ := os.ReadFile(out); strings.Contains(string(data), "typed\n") {
+			return
+		}
+	}
+	data, _ := os.ReadFile(out)
+	t.Fatalf("terminal input did not reach the attached service, got %q", data)
+}
+
+func TestWriteStdin_ChildNotReading(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix sleep")
+	}
+	defer func(d time.Duration) { stdinWriteTimeout = d }(stdinWriteTimeout)
+	s := &S{Name: "deaf", Run: "sleep 30", Output: Output{Stdin: "pipe"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := s.WriteStdin([]byte("x")); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("service didn't accept input: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// more than the pipe buffer holds, which sleep never drains
+	big := make([]byte, 1<<20)
+	stdinWriteTimeout = 200 * time.Millisecond
+	if _, err := s.WriteStdin(big); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the write to time out, got %v", err)
+	}
+
+	// a write blocked on the full pipe must not keep the service from stopping
+	stdinWriteTimeout = time.Minute
+	written := make(chan error, 1)
+	go func() {
+		_, err := s.WriteStdin(big)
+		written <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	stopped := make(chan empty)
+	go func() {
+		cancel()
+		s.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(15 * time.Second):
+		t.Fatal("service didn't stop while a write to its stdin was blocked")
+	}
+	select {
+	case err := <-written:
+		if err == nil {
+			t.Error("expected the blocked write to fail once the service stopped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("blocked write didn't return after the service stopped")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/mertenvg/blade/internal/service"
+)
+
+// envResolver expands variable references in the configuration of a set of
+// services. A reference is written ${VAR} or {$VAR} and may use a shell-like
+// modifier:
+//
+//	${VAR:-default}  default when VAR is unset or empty
+//	${VAR:?message}  fail with message when VAR is unset or empty
+//	${VAR:+alt}      alt when VAR is set and not empty, otherwise empty
+//
+// Without the colon the modifiers only test whether VAR is unset. $$ is a
+// literal $. Variables are looked up in the service's env, whose values may
+// reference each other, then in blade's environment. {$services.<name>.VAR}
+// refers to the env of another service, and only sees blade's environment
+// when that service inherits it.
+type envResolver struct {
+	scopes map[string]*interpolator
+
+	// stack holds the variables being resolved, across services, to detect
+	// reference cycles
+	stack []string
+	// tainted is set when a secret is looked up
+	tainted bool
+}
+
+// newEnvResolver prepares the env of every service for lookups. It has to be
+// created before any service is resolved, as resolving replaces the values
+// other services would read.
+func newEnvResolver(services map[string]*service.S) *envResolver {
+	r := &envResolver{scopes: make(map[string]*interpolator, len(services))}
+	for name, s := range services {
+		in := &interpolator{r: r, service: name, inheritEnv: s.InheritEnv, raw: make(map[string]string), resolved: make(map[string]string), secret: make(map[string]bool)}
+		for _, e := range s.Env {
+			if e.ValueFrom != nil {
+				in.deferred = append(in.deferred, e.Name)
+				in.secret[e.Name] = true
+				continue
+			}
+			// an inline value overrides an inherited valueFrom
+			in.deferred = slices.DeleteFunc(in.deferred, func(name string) bool { return name == e.Name })
+			if e.Value == nil {
+				in.raw[e.Name] = os.Getenv(e.Name)
+			} else {
+				in.raw[e.Name] = *e.Value
+			}
+			in.secret[e.Name] = e.Secret
+		}
+		r.scopes[name] = in
+	}
+	return r
+}
+
+// newInterpolator returns the scope of a single service with the given env,
+// for expanding values without other services.
+func newInterpolator(raw map[string]string) *interpolator {
+	r := newEnvResolver(nil)
+	in := &interpolator{r: r, raw: raw, resolved: make(map[string]string), secret: make(map[string]bool)}
+	r.scopes[""] = in
+	return in
+}
+
+// interpolator expands references against the env of one service.
+type interpolator struct {
+	r          *envResolver
+	service    string
+	inheritEnv bool
+	raw        map[string]string
+	resolved   map[string]string
+	secret     map[string]bool
+
+	// deferred are the variables only known once the service starts
+	deferred []string
+}
+
+// expand replaces every reference in value.
+func (in *interpolator) expand(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); {
+		if strings.HasPrefix(value[i:], "$$") {
+			b.WriteByte('$')
+			i += 2
+			continue
+		}
+		if strings.HasPrefix(value[i:], "${") || strings.HasPrefix(value[i:], "{$") {
+			body, n, ok := braced(value[i+2:])
+			if ok {
+				if v, ok, err := in.reference(body); err != nil {
+					return "", err
+				} else if ok {
+					b.WriteString(v)
+					i += 2 + n
+					continue
+				}
+			}
+		}
+		b.WriteByte(value[i])
+		i++
+	}
+	return b.String(), nil
+}
+
+// braced returns the text up to the '}' closing a reference, and how much of
+// s it used, skipping over nested braces.
+func braced(s string) (string, int, bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return s[:i], i + 1, true
+			}
+			depth--
+		}
+	}
+	return "", 0, false
+}
+
+// reference resolves the body of a reference. It reports false when body
+// isn't a reference at all, e.g. the {$1} of an awk script, so it is kept as
+// written.
+func (in *interpolator) reference(body string) (string, bool, error) {
+	scope, name := in, variableName(body)
+	ref := name
+	if other, ok := strings.CutPrefix(body, "services."); ok {
+		svc, rest, found := strings.Cut(other, ".")
+		if !found || svc == "" || variableName(rest) == "" {
+			return "", false, nil
+		}
+		target, ok := in.r.scopes[svc]
+		if !ok {
+			names := slices.Collect(maps.Keys(in.r.scopes))
+			return "", true, fmt.Errorf("couldn't find service '%s'%s", svc, didYouMean(svc, names))
+		}
+		scope, name = target, variableName(rest)
+		ref = "services." + svc + "." + name
+	}
+	if name == "" {
+		return "", false, nil
+	}
+	rest := body[len(ref):]
+	op, word := "", ""
+	if rest != "" {
+		i := 1
+		if rest[0] == ':' {
+			i = 2
+		}
+		if len(rest) < i || !strings.ContainsRune("-?+", rune(rest[i-1])) {
+			return "", false, nil
+		}
+		op, word = rest[:i], rest[i:]
+	}
+
+	v, set, err := scope.lookup(name, scope == in || scope.inheritEnv)
+	if err != nil {
+		return "", true, err
+	}
+	if !set && op == "" && scope != in {
+		names := append(slices.Collect(maps.Keys(scope.raw)), scope.deferred...)
+		return "", true, fmt.Errorf("%s: service '%s' has no variable '%s'%s", ref, scope.service, name, didYouMean(name, names))
+	}
+	// modifier words belong to the service the reference is written in
+	missing := !set || (strings.HasPrefix(op, ":") && v == "")
+	switch strings.TrimPrefix(op, ":") {
+	case "-":
+		if missing {
+			v, err = in.expand(word)
+		}
+	case "?":
+		if missing {
+			msg, err := in.expand(word)
+			if err != nil {
+				return "", true, err
+			}
+			if msg == "" {
+				msg = "not set"
+				if op == ":?" {
+					msg = "not set or empty"
+				}
+			}
+			return "", true, fmt.Errorf("%s: %s", ref, msg)
+		}
+	case "+":
+		v = ""
+		if !missing {
+			v, err = in.expand(word)
+		}
+	}
+	return v, true, err
+}
+
+// key names a variable of the scope in cycle errors.
+func (in *interpolator) key(name string) string {
+	if in.service == "" {
+		return name
+	}
+	return in.service + "." + name
+}
+
+// lookup returns the value of a variable of the service's env, resolving
+// references in it first, or else of blade's environment when environ is
+// set. A value referring to its own name, as in PATH: {$PATH}:/opt/bin, sees
+// blade's environment.
+func (in *interpolator) lookup(name string, environ bool) (string, bool, error) {
+	if slices.Contains(in.deferred, name) {
+		return "", false, fmt.Errorf("%s is read with valueFrom when the service starts and can't be referenced", in.key(name))
+	}
+	key, stack := in.key(name), in.r.stack
+	raw, ok := in.raw[name]
+	if !ok && !environ {
+		return "", false, nil
+	}
+	if !ok || (len(stack) > 0 && stack[len(stack)-1] == key) {
+		v, ok := os.LookupEnv(name)
+		return v, ok, nil
+	}
+	if v, ok := in.resolved[name]; ok {
+		in.r.tainted = in.r.tainted || in.secret[name]
+		return v, true, nil
+	}
+	if i := slices.Index(stack, key); i >= 0 {
+		cycle := append(slices.Clone(stack[i:]), key)
+		return "", true, fmt.Errorf("interpolation cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	// a value built from a secret is a secret too
+	tainted := in.r.tainted
+	in.r.tainted = false
+	in.r.stack = append(stack, key)
+	v, err := in.expand(raw)
+	in.r.stack = in.r.stack[:len(in.r.stack)-1]
+	if err != nil {
+		return "", true, err
+	}
+	in.secret[name] = in.secret[name] || in.r.tainted
+	in.r.tainted = tainted || in.secret[name]
+	in.resolved[name] = v
+	return v, true, nil
+}
+
+func variableName(s string) string {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return s[:i]
+	}
+	return s
+}
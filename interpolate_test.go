@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mertenvg/blade/internal/service"
+	"github.com/mertenvg/blade/internal/service/watcher"
+)
+
+func TestInterpolator_Expand(t *testing.T) {
+	t.Setenv("BLADE_TEST_SET", "set")
+	t.Setenv("BLADE_TEST_EMPTY", "")
+	t.Setenv("PATH", "/usr/bin")
+
+	in := newInterpolator(map[string]string{
+		"PORT": "8080",
+		"ADDR": "localhost:${PORT}",
+		"PATH": "{$PATH}:/opt/bin",
+	})
+	cases := []struct {
+		in, want string
+	}{
+		{"${ADDR}", "localhost:8080"},
+		{"{$ADDR}", "localhost:8080"},
+		{"${PATH}", "/usr/bin:/opt/bin"},
+		{"${BLADE_TEST_UNSET:-${PORT}}", "8080"},
+		{"${BLADE_TEST_EMPTY:-x}", "x"},
+		{"${BLADE_TEST_EMPTY-x}", ""},
+		{"${BLADE_TEST_SET:-x}", "set"},
+		{"${BLADE_TEST_SET:+alt}", "alt"},
+		{"${BLADE_TEST_EMPTY:+alt}", ""},
+		{"${BLADE_TEST_EMPTY+alt}", "alt"},
+		{"${BLADE_TEST_UNSET}", ""},
+		{"price $$5, $${PORT}", "price $5, ${PORT}"},
+		{"awk '{$1=\"\"}' $HOME", "awk '{$1=\"\"}' $HOME"},
+	}
+	for _, tc := range cases {
+		got, err := in.expand(tc.in)
+		if err != nil {
+			t.Errorf("expand(%q) error: %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("expand(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestInterpolator_Errors(t *testing.T) {
+	t.Setenv("BLADE_TEST_EMPTY", "")
+	in := newInterpolator(map[string]string{"A": "${B}", "B": "x${C}", "C": "{$A}"})
+	cases := map[string]string{
+		"${BLADE_TEST_UNSET:?set it in .env}": "BLADE_TEST_UNSET: set it in .env",
+		"${BLADE_TEST_EMPTY:?}":               "BLADE_TEST_EMPTY: not set or empty",
+		"${BLADE_TEST_UNSET?}":                "BLADE_TEST_UNSET: not set",
+		"${A}":                                "interpolation cycle: A -> B -> C -> A",
+	}
+	for value, want := range cases {
+		_, err := in.expand(value)
+		if err == nil || err.Error() != want {
+			t.Errorf("expand(%q) error = %v, want %q", value, err, want)
+		}
+	}
+}
+
+func TestResolveEnv_ExpandsFields(t *testing.T) {
+	path := "${SRC}/cmd"
+	shared := &watcher.W{FS: &watcher.FSWatcherConfig{Path: &path, Paths: []string{"${SRC}/lib"}}}
+	s := &service.S{
+		Name:   "api",
+		Run:    "./api --port ${PORT}",
+		Dir:    "${SRC:-.}",
+		Watch:  shared,
+		Output: service.Output{Stdout: "file:${LOGS}/{service-name}.log"},
+		Env: []service.EnvValue{
+			{Name: "PORT", Value: strPtr("8080")},
+			{Name: "SRC", Value: strPtr("services/api")},
+			{Name: "LOGS", Value: strPtr("logs")},
+		},
+	}
+	if err := ResolveEnv(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Run != "./api --port 8080" || s.Dir != "services/api" || s.Output.Stdout != "file:logs/{service-name}.log" {
+		t.Errorf("unexpected fields run=%q dir=%q stdout=%q", s.Run, s.Dir, s.Output.Stdout)
+	}
+	if *s.Watch.FS.Path != "services/api/cmd" || s.Watch.FS.Paths[0] != "services/api/lib" {
+		t.Errorf("unexpected watch paths %q %v", *s.Watch.FS.Path, s.Watch.FS.Paths)
+	}
+	if path != "${SRC}/cmd" || shared.FS.Paths[0] != "${SRC}/lib" {
+		t.Error("expanding watch paths changed the config they were inherited from")
+	}
+
+	s = &service.S{Name: "db", Run: "./db ${DB_URL:?DB_URL is required}"}
+	if err := ResolveEnv(s); err == nil || err.Error() != "run: DB_URL: DB_URL is required" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestEnvResolver_CrossService(t *testing.T) {
+	base := &service.S{Name: "base", Env: []service.EnvValue{{Name: "PORT", Value: strPtr("9000")}}}
+	auth := &service.S{Name: "auth", From: service.Parents{"base"}, Env: []service.EnvValue{
+		{Name: "HOST", Value: strPtr("localhost")},
+		{Name: "KEY", Value: strPtr("s3cret"), Secret: true},
+	}}
+	api := &service.S{Name: "api", Env: []service.EnvValue{
+		{Name: "AUTH_URL", Value: strPtr("http://{$services.auth.HOST}:{$services.auth.PORT}")},
+		{Name: "AUTH_KEY", Value: strPtr("${services.auth.KEY}")},
+		{Name: "CACHE", Value: strPtr("${services.cache.URL:-none}")},
+	}}
+	cache := &service.S{Name: "cache"}
+	services := map[string]*service.S{"base": base, "auth": auth, "api": api, "cache": cache}
+	if err := InheritRecursive(auth, services, make(map[*service.S]bool), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newEnvResolver(services)
+	for _, s := range []*service.S{auth, api} {
+		if err := r.ResolveEnv(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := *api.Env[0].Value; got != "http://localhost:9000" {
+		t.Errorf("expected the inherited port of auth, got %q", got)
+	}
+	if !api.Env[1].Secret || api.Env[0].Secret {
+		t.Error("expected only the value taken from a secret to be secret")
+	}
+	if got := *api.Env[2].Value; got != "none" {
+		t.Errorf("unexpected default %q", got)
+	}
+
+	api.Env = []service.EnvValue{{Name: "URL", Value: strPtr("{$services.auht.HOST}")}}
+	if err := newEnvResolver(services).ResolveEnv(api); err == nil || err.Error() != "env URL: couldn't find service 'auht', did you mean 'auth'?" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// blade's environment is only seen through a service inheriting it
+	t.Setenv("BLADE_TEST_HOME", "/home/blade")
+	api.Env = []service.EnvValue{{Name: "URL", Value: strPtr("{$services.auth.BLADE_TEST_HOME}")}}
+	if err := newEnvResolver(services).ResolveEnv(api); err == nil || err.Error() != "env URL: services.auth.BLADE_TEST_HOME: service 'auth' has no variable 'BLADE_TEST_HOME'" {
+		t.Errorf("unexpected error %v", err)
+	}
+	auth.InheritEnv = true
+	if err := newEnvResolver(services).ResolveEnv(api); err != nil || *api.Env[0].Value != "/home/blade" {
+		t.Errorf("expected the environment auth inherits, got %v", err)
+	}
+	auth.InheritEnv = false
+
+	api.Env = []service.EnvValue{{Name: "URL", Value: strPtr("{$services.auth.PROT}")}}
+	if err := newEnvResolver(services).ResolveEnv(api); err == nil || err.Error() != "env URL: services.auth.PROT: service 'auth' has no variable 'PROT', did you mean 'PORT'?" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestEnvResolver_CrossServiceCycle(t *testing.T) {
+	a := &service.S{Name: "a", Env: []service.EnvValue{{Name: "X", Value: strPtr("{$services.b.Y}")}}}
+	b := &service.S{Name: "b", Env: []service.EnvValue{{Name: "Y", Value: strPtr("{$services.a.X}")}}}
+	err := newEnvResolver(map[string]*service.S{"a": a, "b": b}).ResolveEnv(a)
+	if err == nil || err.Error() != "env X: interpolation cycle: a.X -> b.Y -> a.X" {
+		t.Errorf("expected a cycle error, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mertenvg/blade/internal/service"
+	"github.com/mertenvg/blade/pkg/colorterm"
+)
+
+// resolveDependencies looks up the jobs named in the dependsOn: of s, by name
+// or tag, and makes s wait for them.
+func resolveDependencies(s *service.S, services map[string]*service.S, groups map[string][]*service.S) error {
+	if len(s.DependsOn) == 0 {
+		return nil
+	}
+	jobs, err := selectServices(s.DependsOn, services, groups, false)
+	if err != nil {
+		return fmt.Errorf("dependsOn: %w", err)
+	}
+	for _, job := range jobs {
+		switch {
+		case job == s:
+			return fmt.Errorf("dependsOn: '%s' can't depend on itself", s.Name)
+		case !job.IsJob():
+			return fmt.Errorf("dependsOn: '%s' is a long-running service, only jobs (type: job) can be waited for", job.Name)
+		}
+	}
+	s.WaitFor(jobs...)
+	return nil
+}
+
+// dependencyCycle returns the first cycle among the jobs services depend on,
+// like a -> b -> a, or "" when there is none.
+func dependencyCycle(services []*service.S) string {
+	done := make(map[*service.S]bool)
+	var stack []string
+	var visit func(s *service.S) string
+	visit = func(s *service.S) string {
+		if i := slices.Index(stack, s.Name); i >= 0 {
+			return strings.Join(append(stack[i:], s.Name), " -> ")
+		}
+		if done[s] {
+			return ""
+		}
+		stack = append(stack, s.Name)
+		for _, dep := range s.Dependencies() {
+			if cycle := visit(dep); cycle != "" {
+				return cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		done[s] = true
+		return ""
+	}
+	for _, s := range services {
+		if cycle := visit(s); cycle != "" {
+			return cycle
+		}
+	}
+	return ""
+}
+
+// withDependencies adds the jobs the services of run depend on, directly or
+// not, to run, ahead of the first service needing them. Jobs are added even
+// when they are marked skip: true.
+func withDependencies(run []*service.S) []*service.S {
+	var out []*service.S
+	added := make(map[*service.S]bool)
+	var add func(s *service.S)
+	add = func(s *service.S) {
+		if added[s] {
+			return
+		}
+		added[s] = true
+		for _, dep := range s.Dependencies() {
+			add(dep)
+		}
+		out = append(out, s)
+	}
+	for _, s := range run {
+		add(s)
+	}
+	return out
+}
+
+// untilJobsComplete waits for every job in run to end, prints how each one
+// did and reports whether all of them succeeded. It fails when ctx is done
+// before they have.
+func untilJobsComplete(ctx context.Context, run []*service.S) bool {
+	var jobs []*service.S
+	for _, s := range run {
+		if s.IsJob() {
+			jobs = append(jobs, s)
+		}
+	}
+	if len(jobs) == 0 {
+		colorterm.Warning("no jobs to wait for")
+		return true
+	}
+	results := make([]service.JobResult, len(jobs))
+	for i, job := range jobs {
+		r, err := job.WaitJob(ctx)
+		if err != nil {
+			colorterm.Error("stopped before every job completed")
+			return false
+		}
+		results[i] = r
+	}
+
+	failed := 0
+	for i, job := range jobs {
+		if results[i].OK() {
+			colorterm.Success(" -", job.Name, results[i])
+			continue
+		}
+		colorterm.Error(" -", job.Name, results[i])
+		failed++
+	}
+	if failed > 0 {
+		colorterm.Errorf("%d of %d job(s) failed", failed, len(jobs))
+		return false
+	}
+	colorterm.Successf("%d job(s) completed", len(jobs))
+	return true
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mertenvg/blade/internal/service"
+)
+
+func TestResolveDependencies(t *testing.T) {
+	migrate := &service.S{Name: "migrate", Type: service.TypeJob, Skip: true}
+	seed := &service.S{Name: "seed", Type: service.TypeJob, Tags: []string{"setup"}, DependsOn: []string{"migrate"}}
+	api := &service.S{Name: "api", DependsOn: []string{"setup"}}
+	web := &service.S{Name: "web", DependsOn: []string{"api"}}
+	services := map[string]*service.S{"migrate": migrate, "seed": seed, "api": api, "web": web}
+	groups := map[string][]*service.S{"setup": {seed}}
+
+	for _, s := range []*service.S{migrate, seed, api} {
+		if err := resolveDependencies(s, services, groups); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := resolveDependencies(web, services, groups); err == nil || err.Error() != "dependsOn: 'api' is a long-running service, only jobs (type: job) can be waited for" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if cycle := dependencyCycle([]*service.S{api, seed, migrate}); cycle != "" {
+		t.Errorf("unexpected cycle %s", cycle)
+	}
+
+	// a skipped job still runs ahead of the services needing it
+	var names []string
+	for _, s := range withDependencies([]*service.S{api}) {
+		names = append(names, s.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"migrate", "seed", "api"}) {
+		t.Errorf("unexpected run %v", names)
+	}
+
+	migrate.DependsOn = []string{"seed"}
+	if err := resolveDependencies(migrate, services, groups); err != nil {
+		t.Fatal(err)
+	}
+	if cycle := dependencyCycle([]*service.S{api, seed, migrate}); cycle != "seed -> migrate -> seed" {
+		t.Errorf("unexpected cycle %q", cycle)
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
 	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -15,20 +19,34 @@ import (
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/mertenvg/blade/internal/control"
+	"github.com/mertenvg/blade/internal/proxy"
 	"github.com/mertenvg/blade/internal/service"
 	"github.com/mertenvg/blade/pkg/colorterm"
+	"github.com/mertenvg/blade/pkg/redact"
 )
 
 const RecursionLimit = 10
 
-var envVarValuePlaceholder = regexp.MustCompile(`\{\$([a-zA-Z_][a-zA-Z0-9_]*)}`)
+// configFile is the raw content of a single YAML configuration file.
+type configFile struct {
+	Path string
+	Data []byte
 
-func ReadDirRecursive(dir string, depth int) []byte {
+	// Dir is the directory relative paths in the file are resolved against,
+	// relative to the project root blade runs in.
+	Dir string
+}
+
+// configNames are the files and directory blade looks for in a project root.
+var configNames = []string{"blade.yaml", "blade.yml", ".blade"}
+
+func ReadDirRecursive(dir string, depth int) []configFile {
 	if depth > RecursionLimit {
 		colorterm.Warningf("recursion limit (%v) reached at '%s'", RecursionLimit, dir)
 		return nil
 	}
-	var buf bytes.Buffer
+	var files []configFile
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		colorterm.Warningf("couldn't read from '%s': %s", dir, err)
@@ -36,19 +54,19 @@ func ReadDirRecursive(dir string, depth int) []byte {
 	}
 	for _, e := range entries {
 		if e.IsDir() {
-			data := ReadDirRecursive(filepath.Join(dir, e.Name()), depth+1)
-			if data != nil {
-				buf.Write(data)
-			}
+			files = append(files, ReadDirRecursive(filepath.Join(dir, e.Name()), depth+1)...)
 			continue
 		}
 		if !slices.Contains([]string{".yaml", ".yml"}, strings.ToLower(filepath.Ext(e.Name()))) {
 			// not a YAML file, skipping!
 			continue
 		}
-		buf.Write(TryFile(filepath.Join(dir, e.Name())))
+		path := filepath.Join(dir, e.Name())
+		if data := TryFile(path); data != nil {
+			files = append(files, configFile{Path: path, Data: data})
+		}
 	}
-	return buf.Bytes()
+	return files
 }
 
 func TryFile(path string) []byte {
@@ -66,54 +84,636 @@ func TryFile(path string) []byte {
 	return nil
 }
 
-func LoadConfig() []byte {
-	if data := TryFile("./blade.yaml"); data != nil {
-		return data
+// LoadConfig reads the configuration at each of paths, in order, or the one in
+// the current directory when paths is empty. A path is either a YAML file or a
+// project directory holding blade.yaml, blade.yml or .blade/.
+func LoadConfig(paths []string) []configFile {
+	if len(paths) == 0 {
+		return loadProject(".")
+	}
+	var files []configFile
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			colorterm.Warningf("Couldn't load config '%s': %v", path, err)
+			continue
+		}
+		if fi.IsDir() {
+			files = append(files, loadProject(path)...)
+			continue
+		}
+		if data := TryFile(path); data != nil {
+			files = append(files, configFile{Path: path, Data: data, Dir: filepath.Dir(path)})
+		}
 	}
-	if data := TryFile("./blade.yml"); data != nil {
-		return data
+	for i, f := range files {
+		files[i].Path = relativePath(f.Path)
+		files[i].Dir = relativePath(f.Dir)
 	}
-	if data := ReadDirRecursive("./.blade", 0); data != nil {
-		return data
+	return files
+}
+
+func loadProject(dir string) []configFile {
+	for _, name := range []string{"blade.yaml", "blade.yml"} {
+		path := filepath.Join(dir, name)
+		if data := TryFile(path); data != nil {
+			return []configFile{{Path: path, Data: data, Dir: dir}}
+		}
 	}
-	return nil
+	files := ReadDirRecursive(filepath.Join(dir, ".blade"), 0)
+	for i := range files {
+		files[i].Dir = dir
+	}
+	return files
 }
 
-func InheritRecursive(child *service.S, lookup map[string]*service.S, depth int) {
-	if depth > RecursionLimit {
-		colorterm.Warningf("recursion limit (%v) reached at '%s'", RecursionLimit, child.Name)
+// FindRoot returns the directory blade runs in: the directory of the first of
+// paths or, without paths, the nearest directory at or above the current one
+// that holds blade configuration. It falls back to the current directory.
+func FindRoot(paths []string) (string, error) {
+	if len(paths) > 0 {
+		fi, err := os.Stat(paths[0])
+		if err != nil {
+			return "", err
+		}
+		if fi.IsDir() {
+			return paths[0], nil
+		}
+		return filepath.Dir(paths[0]), nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for dir := cwd; ; dir = filepath.Dir(dir) {
+		for _, name := range configNames {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return dir, nil
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return cwd, nil
+		}
+	}
+}
+
+// globalFlags are the flags blade takes before or after the command, each
+// with a value.
+var globalFlags = []string{"f", "config", "profile"}
+
+// extractFlag removes every occurrence of the named flags, given as -name
+// value or -name=value with one or two dashes, from args, the program name
+// followed by the command and its arguments, and returns their values and the
+// remaining arguments. Scanning stops at "--", and at the text `blade send`
+// passes to a service, so `blade send svc -f x` sends "-f x".
+func extractFlag(args []string, names ...string) ([]string, []string) {
+	var values, rest, positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || len(positional) == 3 && positional[1] == "send" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if i == 0 || !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			rest = append(rest, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !slices.Contains(names, name) {
+			rest = append(rest, arg)
+			if slices.Contains(globalFlags, name) && !hasValue && i+1 < len(args) {
+				// the value of another global flag isn't an argument
+				i++
+				rest = append(rest, args[i])
+			}
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				colorterm.Errorf("flag needs an argument: %s", arg)
+				os.Exit(2)
+			}
+			i++
+			value = args[i]
+		}
+		values = append(values, value)
+	}
+	return values, rest
+}
+
+// configPaths removes every -f/--config flag from args and returns the
+// remaining arguments and the flag values made absolute, or the entries of
+// BLADE_CONFIG when no flag is given.
+func configPaths(args []string) ([]string, []string) {
+	paths, rest := extractFlag(args, "f", "config")
+	if len(paths) == 0 {
+		if env := os.Getenv("BLADE_CONFIG"); env != "" {
+			paths = filepath.SplitList(env)
+		}
+	}
+	for i, p := range paths {
+		if abs, err := filepath.Abs(p); err == nil {
+			paths[i] = abs
+		}
+	}
+	return paths, rest
+}
+
+// activeProfiles removes every --profile flag from args and returns the
+// remaining arguments and the profiles to apply, in order, or those in
+// BLADE_PROFILE when no flag is given. Both accept comma separated lists.
+func activeProfiles(args []string) ([]string, []string) {
+	values, rest := extractFlag(args, "profile")
+	if len(values) == 0 {
+		if env := os.Getenv("BLADE_PROFILE"); env != "" {
+			values = []string{env}
+		}
+	}
+	var profiles []string
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" && !slices.Contains(profiles, name) {
+				profiles = append(profiles, name)
+			}
+		}
+	}
+	return profiles, rest
+}
+
+// relativePath makes path relative to the current directory when it is below
+// it, for shorter messages.
+func relativePath(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// rebase resolves the relative dir and watch paths of s against dir, the
+// directory of the file s was defined in.
+func rebase(s *service.S, dir string) {
+	if dir == "" || dir == "." {
 		return
 	}
-	if child.From != "" {
-		if parent, ok := lookup[child.From]; ok {
-			if parent != child {
-				InheritRecursive(parent, lookup, depth+1)
+	join := func(p string) string {
+		// a path starting with a variable is taken as it expands, so
+		// dir: ${HOME}/src still works after the join
+		if filepath.IsAbs(p) || strings.HasPrefix(p, "$") || strings.HasPrefix(p, "{$") {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	s.Dir = join(s.Dir)
+	if s.Watch != nil && s.Watch.FS != nil {
+		if s.Watch.FS.Path != nil {
+			p := join(*s.Watch.FS.Path)
+			s.Watch.FS.Path = &p
+		}
+		for i, p := range s.Watch.FS.Paths {
+			s.Watch.FS.Paths[i] = join(p)
+		}
+	}
+}
+
+// ResolveEnv resolves s on its own, where references to other services
+// can't be resolved. See (*envResolver).ResolveEnv.
+func ResolveEnv(s *service.S) error {
+	return newEnvResolver(map[string]*service.S{s.Name: s}).ResolveEnv(s)
+}
+
+// ResolveEnv fills in env values taken from blade's environment and expands
+// variable references in env values, noting both in the origin of each value.
+// References in the commands, directory, watch paths and output paths of s
+// are expanded too, against the resolved env. Values that reference a secret
+// become secrets themselves.
+func (r *envResolver) ResolveEnv(s *service.S) error {
+	in := r.scopes[s.Name]
+	for i, e := range s.Env {
+		if e.ValueFrom != nil {
+			// read when the service starts, never at load
+			if e.ValueFrom.Command != "" {
+				s.Env[i].Origin += fmt.Sprintf("; read from command '%s'", e.ValueFrom.Command)
+			} else {
+				s.Env[i].Origin += fmt.Sprintf("; read from file '%s'", e.ValueFrom.File)
+			}
+			continue
+		}
+		if e.Value == nil {
+			s.Env[i].Origin += "; taken from environment"
+		}
+		r.tainted = false
+		v, _, err := in.lookup(e.Name, true)
+		if err != nil {
+			return fmt.Errorf("env %s: %w", e.Name, err)
+		}
+		if raw := in.raw[e.Name]; v != raw {
+			s.Env[i].Origin += fmt.Sprintf("; interpolated from '%s'", raw)
+		}
+		s.Env[i].Value = &v
+		if in.secret[e.Name] {
+			s.Env[i].Secret = true
+			redact.Add(v)
+		}
+	}
+
+	for _, f := range expandedFields(s) {
+		v, err := in.expand(*f.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.value = v
+	}
+	return nil
+}
+
+// expandedField is a value of a service, other than env, that references
+// are expanded in.
+type expandedField struct {
+	name  string
+	value *string
+}
+
+// expandedFields returns the values of s that references are expanded in.
+// The watch and readiness configs may be shared with the service s inherits
+// them from, so s gets fresh copies the returned values point into.
+func expandedFields(s *service.S) []expandedField {
+	fields := []expandedField{
+		{"once", &s.Once},
+		{"before", &s.Before},
+		{"run", &s.Run},
+		{"dir", &s.Dir},
+		{"output.stdout", &s.Output.Stdout},
+		{"output.stderr", &s.Output.Stderr},
+		{"output.stdin", &s.Output.Stdin},
+	}
+	if s.Watch != nil && s.Watch.FS != nil {
+		fs := *s.Watch.FS
+		if fs.Path != nil {
+			path := *fs.Path
+			fs.Path = &path
+			fields = append(fields, expandedField{"watch.fs.path", fs.Path})
+		}
+		fs.Paths = slices.Clone(fs.Paths)
+		for i := range fs.Paths {
+			fields = append(fields, expandedField{"watch.fs.paths", &fs.Paths[i]})
+		}
+		w := *s.Watch
+		w.FS = &fs
+		s.Watch = &w
+	}
+	if s.Readiness != nil {
+		r := *s.Readiness
+		fields = append(fields, expandedField{"readiness.tcp", &r.TCP}, expandedField{"readiness.http", &r.HTTP})
+		s.Readiness = &r
+	}
+	return fields
+}
+
+// configError is a problem found in a configuration file.
+type configError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e configError) String() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Msg)
+}
+
+// configItem is a service decoded from its own node, so that later checks can
+// point back at the YAML it came from.
+type configItem struct {
+	*service.S
+	Node *yaml.Node
+
+	// Invalid is set when the node couldn't be decoded cleanly.
+	Invalid bool
+	// Profile is set when the item is an overlay in that profile.
+	Profile string
+}
+
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// topLevelKeys are the keys allowed when a configuration file is a mapping
+// rather than a plain list of services.
+var topLevelKeys = []string{"include", "services", "profiles", "proxy"}
+
+// parseConfig decodes every file, and every service in it, on its own. A file
+// with a syntax error or a service with a bad value is reported and left out
+// without affecting the rest of the configuration. Files named in include:
+// are loaded after the services of the file including them. It also returns
+// the number of files read, including those pulled in through include:.
+func parseConfig(files []configFile) ([]configItem, []configError, int) {
+	p := &configParser{seen: make(map[string]bool)}
+	for _, f := range files {
+		p.markSeen(f.Path)
+	}
+	var items []configItem
+	for _, f := range files {
+		items = append(items, p.parse(f, nil)...)
+	}
+	for _, item := range items {
+		env := mappingValue(item.Node, "env")
+		if env == nil || env.Kind != yaml.SequenceNode {
+			continue
+		}
+		name := item.Name
+		if item.Profile != "" {
+			name = fmt.Sprintf("%s in profile %s", item.Name, item.Profile)
+		}
+		for i := range item.Env {
+			if i < len(env.Content) {
+				item.Env[i].Origin = fmt.Sprintf("%s (%s:%d)", name, item.Source.File, env.Content[i].Line)
 			}
-			child.InheritFrom(parent)
 		}
 	}
+	return items, p.errs, p.files
 }
 
-func ResolveValueRecursive(value string, lookup map[string]string, depth int) string {
-	if depth > RecursionLimit {
-		colorterm.Warningf("recursion limit (%v) reached at '%s'", RecursionLimit, value)
-		return value
-	}
-	matches := envVarValuePlaceholder.FindAllStringSubmatch(value, -1)
-	if matches != nil {
-		for _, m := range matches {
-			v, ok := lookup[m[1]]
-			if !ok {
-				v = os.Getenv(m[1])
+type configParser struct {
+	seen  map[string]bool
+	errs  []configError
+	files int
+}
+
+func (p *configParser) markSeen(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		p.seen[abs] = true
+	}
+}
+
+// parse decodes the services of f followed by those of the files it includes.
+// stack holds the absolute paths of the files that included f.
+func (p *configParser) parse(f configFile, stack []string) []configItem {
+	p.files++
+	var doc yaml.Node
+	if err := yaml.Unmarshal(f.Data, &doc); err != nil {
+		p.errs = append(p.errs, yamlErrors(f.Path, err)...)
+		return nil
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	list := doc.Content[0]
+	var includes, profiles *yaml.Node
+	if list.Kind == yaml.MappingNode {
+		root := list
+		list = nil
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			key, value := root.Content[i], root.Content[i+1]
+			switch key.Value {
+			case "services":
+				list = value
+			case "include":
+				includes = value
+			case "profiles":
+				profiles = value
+			case "proxy":
+				// read by ParseProxy
+				if len(stack) > 0 {
+					p.errs = append(p.errs, configError{f.Path, key.Line, "proxy: only allowed in the top-level configuration, not in included files"})
+				}
+			default:
+				p.errs = append(p.errs, configError{f.Path, key.Line, fmt.Sprintf("unknown top-level key '%s'%s", key.Value, didYouMean(key.Value, topLevelKeys))})
+			}
+		}
+	}
+
+	items := p.services(f, list, "")
+	if profiles != nil && profiles.Kind != yaml.MappingNode {
+		p.errs = append(p.errs, configError{f.Path, profiles.Line, "profiles: expected a mapping of profile names to lists of services"})
+	} else if profiles != nil {
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			items = append(items, p.services(f, profiles.Content[i+1], profiles.Content[i].Value)...)
+		}
+	}
+
+	if includes != nil {
+		items = append(items, p.include(f, includes, stack)...)
+	}
+	return items
+}
+
+// services decodes the list of services in f, which are overlays of profile
+// when it is set.
+func (p *configParser) services(f configFile, list *yaml.Node, profile string) []configItem {
+	if list == nil {
+		return nil
+	}
+	if list.Kind != yaml.SequenceNode {
+		p.errs = append(p.errs, configError{f.Path, list.Line, "expected a list of services"})
+		return nil
+	}
+	var items []configItem
+	for _, node := range list.Content {
+		if node.Kind != yaml.MappingNode {
+			p.errs = append(p.errs, configError{f.Path, node.Line, "expected a service definition"})
+			continue
+		}
+		item := configItem{S: &service.S{}, Node: node, Profile: profile}
+		if err := node.Decode(item.S); err != nil {
+			p.errs = append(p.errs, yamlErrors(f.Path, err)...)
+			item.Invalid = true
+		}
+		item.Source = service.Source{File: f.Path, Line: node.Line}
+		rebase(item.S, f.Dir)
+		items = append(items, item)
+	}
+	return items
+}
+
+// yamlErrors splits a yaml.v3 error into one configError per reported line.
+func yamlErrors(file string, err error) []configError {
+	var msgs []string
+	var te *yaml.TypeError
+	if errors.As(err, &te) {
+		msgs = te.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+	errs := make([]configError, 0, len(msgs))
+	for _, m := range msgs {
+		if sub := yamlErrorLine.FindStringSubmatch(m); sub != nil {
+			line, _ := strconv.Atoi(sub[1])
+			errs = append(errs, configError{file, line, sub[2]})
+			continue
+		}
+		errs = append(errs, configError{file, 0, strings.TrimPrefix(m, "yaml: ")})
+	}
+	return errs
+}
+
+func sortConfigErrors(errs []configError) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].File != errs[j].File {
+			return errs[i].File < errs[j].File
+		}
+		return errs[i].Line < errs[j].Line
+	})
+}
+
+// InheritRecursive merges the parents named in the from: list of child into
+// it, resolving each parent's own parents first. resolved holds the services
+// that are complete, and stack the chain of services being resolved, which
+// is how cycles are detected.
+func InheritRecursive(child *service.S, lookup map[string]*service.S, resolved map[*service.S]bool, stack []string) error {
+	if resolved[child] {
+		return nil
+	}
+	if i := slices.Index(stack, child.Name); i >= 0 {
+		return fmt.Errorf("inheritance cycle: %s", strings.Join(append(slices.Clone(stack[i:]), child.Name), " -> "))
+	}
+	stack = append(stack, child.Name)
+	// the parents are overlaid left to right, so later ones override earlier
+	// ones, and child inherits from the result as from a single parent
+	var merged *service.S
+	for _, from := range child.From {
+		parent, ok := lookup[from]
+		if !ok {
+			colorterm.Warningf("%s: from: unknown service '%s'", child.Name, from)
+			continue
+		}
+		if err := InheritRecursive(parent, lookup, resolved, stack); err != nil {
+			return err
+		}
+		if merged == nil {
+			merged = &service.S{}
+		}
+		merged.Overlay(parent)
+	}
+	if merged != nil {
+		child.InheritFrom(merged)
+	}
+	resolved[child] = true
+	return nil
+}
+
+// selectServices returns the services named by tokens, each of which is a
+// service name or a tag, without duplicates and in the order given. Abstract
+// services may only be named when abstract is set.
+func selectServices(tokens []string, services map[string]*service.S, groups map[string][]*service.S, abstract bool) ([]*service.S, error) {
+	var selected []*service.S
+	added := make(map[string]struct{})
+	add := func(s *service.S) {
+		if _, seen := added[s.Name]; !seen {
+			selected = append(selected, s)
+			added[s.Name] = struct{}{}
+		}
+	}
+	for _, token := range tokens {
+		if s, ok := services[token]; ok {
+			if s.Abstract && !abstract {
+				return nil, fmt.Errorf("'%s' is abstract and can only be used with from:", token)
+			}
+			add(s)
+			continue
+		}
+		if gs, ok := groups[token]; ok {
+			for _, s := range gs {
+				add(s)
 			}
-			value = strings.ReplaceAll(value, m[0], ResolveValueRecursive(v, lookup, depth+1))
+			continue
 		}
+		return nil, fmt.Errorf("couldn't find service or group '%s'", token)
+	}
+	return selected, nil
+}
+
+// expandReplicas replaces every service with replicas: by its replicas, in
+// conf, services and the groups it is tagged with. The name of the service
+// becomes a group of its own, so it still selects the whole set.
+func expandReplicas(conf []*service.S, services map[string]*service.S, groups map[string][]*service.S) ([]*service.S, error) {
+	var expanded []*service.S
+	for _, s := range conf {
+		if s.Abstract {
+			// its replicas: is inherited, the services using it are expanded
+			expanded = append(expanded, s)
+			continue
+		}
+		set, err := s.ReplicaSet()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s invalid configuration: %w", s.Source, s.Name, err)
+		}
+		if set == nil {
+			expanded = append(expanded, s)
+			continue
+		}
+		for _, r := range set {
+			if other, ok := services[r.Name]; ok {
+				return nil, fmt.Errorf("%s: replica %s of %s clashes with the service defined at %s", s.Source, r.Name, s.Name, other.Source)
+			}
+			services[r.Name] = r
+		}
+		delete(services, s.Name)
+		for t, gs := range groups {
+			if i := slices.Index(gs, s); i >= 0 {
+				groups[t] = slices.Replace(gs, i, i+1, set...)
+			}
+		}
+		groups[s.Name] = append(groups[s.Name], set...)
+		expanded = append(expanded, set...)
+	}
+	return expanded, nil
+}
+
+// parseInterspersed parses flags that may appear anywhere among the
+// positional arguments, and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		_ = fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
 	}
-	return value
 }
 
 func main() {
-	args := os.Args
+	if len(os.Args) > 1 {
+		// started by blade in place of a service holding sockets or with
+		// process attributes
+		var err error
+		switch os.Args[1] {
+		case service.ListenExecCommand:
+			err = service.ListenExec(os.Args[2:])
+		case service.ProcExecCommand:
+			err = service.ProcExec(os.Args[2:])
+		}
+		if err != nil {
+			colorterm.Error(err)
+			os.Exit(127)
+		}
+	}
+
+	paths, args := configPaths(os.Args)
+	profiles, args := activeProfiles(args)
+
+	root, err := FindRoot(paths)
+	if err != nil {
+		colorterm.Error("Couldn't find config:", err)
+		os.Exit(1)
+	}
+	// relative paths in the configuration, and the control socket, are
+	// relative to the project root
+	if err := os.Chdir(root); err != nil {
+		colorterm.Error("Couldn't enter", root, err)
+		os.Exit(1)
+	}
 
 	if len(args) > 1 {
 		switch args[1] {
@@ -126,57 +726,184 @@ func main() {
 		case "update":
 			update()
 			return
+		case "ps":
+			ps(args[2:])
+			return
+		case "top":
+			top(args[2:])
+			return
+		case "attach":
+			attach(args[2:])
+			return
+		case "send":
+			send(args[2:])
+			return
+		case "restart", "stop":
+			restart(args[1], args[2:])
+			return
+		case "schema":
+			printSchema()
+			return
 		}
 	}
 
-	data := LoadConfig()
-	if len(data) == 0 {
-		colorterm.Error("Couldn't find config: expected ./blade.yaml or ./blade directory with YAML files")
+	files := LoadConfig(paths)
+	if len(files) == 0 {
+		colorterm.Error("Couldn't find config: expected blade.yaml, blade.yml or a .blade directory with YAML files in this or a parent directory, or use -f <path>")
 		os.Exit(1)
 	}
 
-	var conf []*service.S
+	command := ""
+	if len(args) > 1 {
+		command = args[1]
+	}
+
+	runFlags := flag.NewFlagSet("run", flag.ExitOnError)
+	noValidate := runFlags.Bool("no-validate", false, "start services even if the configuration has problems")
+	killConflicts := runFlags.Bool("kill-conflicts", false, "stop processes holding the ports of a service before starting it")
+	untilJobs := runFlags.Bool("until-jobs-complete", false, "stop once every job has ended, failing if any of them failed")
+	var selected []string
+	if command == "run" {
+		selected = parseInterspersed(runFlags, args[2:])
+	}
+
+	validating := command == "validate" || command == "run"
+	items, problems, loaded := parseConfig(files)
+	proxyConf, proxyProblems := ParseProxy(files)
+	problems = append(problems, proxyProblems...)
+	if validating {
+		problems = append(problems, validateKeys(items)...)
+	}
+	available := profileNames(items)
+	items, err = ApplyProfiles(items, profiles)
+	if err != nil {
+		colorterm.Error(err)
+		os.Exit(1)
+	}
+	if validating {
+		problems = append(problems, validateServices(items)...)
+	}
+	sortConfigErrors(problems)
 
-	if err := yaml.Unmarshal(data, &conf); err != nil {
-		colorterm.Error("Couldn't parse configuration:", err)
+	if len(problems) > 0 && validating && (command == "validate" || !*noValidate) {
+		for _, p := range problems {
+			colorterm.Error(p)
+		}
+		colorterm.Errorf("%d problem(s) found in configuration", len(problems))
+		if command == "run" {
+			colorterm.None("Fix them, or use `blade run --no-validate` to start anyway")
+		}
 		os.Exit(1)
 	}
+	for _, p := range problems {
+		colorterm.Warning(p)
+	}
+	if len(problems) > 0 && command == "run" {
+		colorterm.Warning("starting despite configuration problems (--no-validate)")
+	}
+
+	var conf []*service.S
+	for _, item := range items {
+		if item.Invalid {
+			colorterm.Warning(item.Source, "skipping service", item.Name)
+			continue
+		}
+		conf = append(conf, item.S)
+	}
 
 	services := make(map[string]*service.S)
 	groups := make(map[string][]*service.S)
 	for _, s := range conf {
 		services[s.Name] = s
-		if len(s.Tags) > 0 {
+		if len(s.Tags) > 0 && !s.Abstract {
 			for _, t := range s.Tags {
 				groups[t] = append(groups[t], s)
 			}
 		}
 	}
 
+	// resolve inheritance for every service before any env, so placeholders
+	// in inherited values see the values of the service inheriting them
+	resolved := make(map[*service.S]bool)
 	for _, s := range conf {
-		// resolve inheritance
-		InheritRecursive(s, services, 0)
-
-		// resolve env values
-		env := make(map[string]string)
-		for _, e := range s.Env {
-			var v string
-			if e.Value == nil {
-				v = os.Getenv(e.Name)
-			} else {
-				v = *e.Value
-			}
-			env[e.Name] = v
+		if err := InheritRecursive(s, services, resolved, nil); err != nil {
+			colorterm.Error(s.Source, err)
+			os.Exit(1)
+		}
+	}
+	conf, err = expandReplicas(conf, services, groups)
+	if err != nil {
+		colorterm.Error(err)
+		os.Exit(1)
+	}
+	// ports are picked before interpolation, so their env is available to
+	// every service
+	runnable := slices.DeleteFunc(slices.Clone(conf), func(s *service.S) bool { return s.Abstract })
+	if err := service.AllocatePorts(runnable); err != nil {
+		colorterm.Error(err)
+		os.Exit(1)
+	}
+
+	// after inheritance, so references to other services see their inherited
+	// env too
+	envs := newEnvResolver(services)
+	for _, s := range conf {
+		if s.Abstract {
+			// expanded in the services inheriting from it instead
+			continue
 		}
-		for n, e := range env {
-			env[n] = ResolveValueRecursive(e, env, 0)
+		if err := envs.ResolveEnv(s); err != nil {
+			colorterm.Errorf("%s: %s: %v", s.Source, s.Name, err)
+			os.Exit(1)
 		}
-		for i, e := range s.Env {
-			v := env[e.Name]
-			s.Env[i].Value = &v
+		if s.HasSecrets() {
+			// valueFrom secrets are read as services start, by when others
+			// may already be running and print them
+			redact.Expect()
 		}
 	}
 
+	// templates only exist to be inherited from, so they are left out of
+	// listings, status and runs, though still found by name for from:
+	conf = slices.DeleteFunc(conf, func(s *service.S) bool { return s.Abstract })
+
+	if command == "config" {
+		printConfig(args[2:], conf, services, groups, profiles)
+		return
+	}
+
+	invalid := false
+	for _, s := range conf {
+		if err := s.Validate(); err != nil {
+			colorterm.Errorf("%s: %s invalid configuration: %v", s.Source, s.Name, err)
+			invalid = true
+			continue
+		}
+		if err := resolveDependencies(s, services, groups); err != nil {
+			colorterm.Errorf("%s: %s invalid configuration: %v", s.Source, s.Name, err)
+			invalid = true
+		}
+	}
+	if cycle := dependencyCycle(conf); cycle != "" {
+		colorterm.Error("dependsOn: cycle:", cycle)
+		invalid = true
+	}
+	var px *proxy.Proxy
+	if proxyConf != nil {
+		if px, err = newProxy(*proxyConf, conf); err != nil {
+			colorterm.Error(err)
+			invalid = true
+		}
+	}
+	if invalid {
+		os.Exit(1)
+	}
+
+	if command == "validate" {
+		colorterm.Successf("configuration is valid (%d services in %d files)", len(conf), loaded)
+		return
+	}
+
 	var wg sync.WaitGroup
 
 	defer func() {
@@ -195,27 +922,11 @@ func main() {
 		switch action {
 		case "run":
 			var run []*service.S
-			if len(args) > 2 {
+			if len(selected) > 0 {
 				// allow selecting by service name or group name
-				added := make(map[string]struct{})
-				for _, token := range args[2:] {
-					if s, ok := services[token]; ok {
-						if _, seen := added[s.Name]; !seen {
-							run = append(run, s)
-							added[s.Name] = struct{}{}
-						}
-						continue
-					}
-					if gs, ok := groups[token]; ok {
-						for _, s := range gs {
-							if _, seen := added[s.Name]; !seen {
-								run = append(run, s)
-								added[s.Name] = struct{}{}
-							}
-						}
-						continue
-					}
-					colorterm.Error("Couldn't find service or group", token)
+				var err error
+				if run, err = selectServices(selected, services, groups, false); err != nil {
+					colorterm.Error(err)
 					os.Exit(1)
 				}
 			} else {
@@ -227,13 +938,56 @@ func main() {
 					run = append(run, s)
 				}
 			}
+			// jobs needed by the services selected run too, ahead of them
+			run = withDependencies(run)
+
+			// deferred first, so it runs after every cleanup below
+			exitCode := 0
+			defer func() {
+				if exitCode != 0 {
+					os.Exit(exitCode)
+				}
+			}()
 
 			// Single root context governs every service and watcher.
 			rootCtx, rootCancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 			defer rootCancel()
 
+			router := service.NewStdinRouter()
+			for _, s := range run {
+				router.Add(s)
+			}
+			if router.Len() > 0 {
+				colorterm.Info("stdin attached to", router.Focused(), "(switch with `blade attach <name>`)")
+				go router.Run(os.Stdin)
+			}
+
+			ctl := control.NewServer(control.SocketPath("."))
+			ctl.Handle("status", statusHandler(services, groups, conf))
+			ctl.Handle("attach", attachHandler(router))
+			ctl.Handle("stdin", stdinHandler(services))
+			ctl.Handle("restart", lifecycleHandler(services, groups, (*service.S).Restart))
+			ctl.Handle("stop", lifecycleHandler(services, groups, (*service.S).Exit))
+			if err := ctl.Start(rootCtx); err != nil {
+				colorterm.Warning("control socket unavailable, commands like `blade ps` won't work:", err)
+			} else {
+				defer ctl.Close()
+			}
+
+			if exe, err := os.Executable(); err == nil {
+				service.ExecShim = exe
+			}
+
+			if px != nil {
+				if err := px.Start(rootCtx, run); err != nil {
+					colorterm.Error(err)
+					os.Exit(1)
+				}
+			}
+
 			for _, s := range run {
 				wg.Add(1)
+				s.KillConflicts = *killConflicts
 				s.Start(rootCtx)
 				go func(s *service.S) {
 					s.Wait()
@@ -241,6 +995,14 @@ func main() {
 				}(s)
 			}
 
+			jobsOK := make(chan bool, 1)
+			if *untilJobs {
+				go func() {
+					jobsOK <- untilJobsComplete(rootCtx, run)
+					rootCancel()
+				}()
+			}
+
 			info := make(chan os.Signal, 1)
 			if len(infoSignals) > 0 {
 				signal.Notify(info, infoSignals...)
@@ -252,14 +1014,7 @@ func main() {
 					case <-rootCtx.Done():
 						return
 					case <-info:
-						for _, s := range conf {
-							active, state, pid := s.Status()
-							if active {
-								colorterm.Success(s.Name, pid, state)
-							} else {
-								colorterm.Error(s.Name, pid, state)
-							}
-						}
+						printStatusDump(conf)
 					}
 				}
 			}()
@@ -281,6 +1036,9 @@ func main() {
 				colorterm.Error("services did not exit in time, forcing")
 				os.Exit(1)
 			}
+			if *untilJobs && !<-jobsOK {
+				exitCode = 1
+			}
 			return
 		}
 	} else {
@@ -294,8 +1052,23 @@ func main() {
 				colorterm.Info(" -", g)
 			}
 		}
-		colorterm.None("Usage: blade run")
+		if len(available) > 0 {
+			colorterm.Info("Profiles available:")
+			for _, p := range available {
+				if slices.Contains(profiles, p) {
+					colorterm.Info(" -", p, "(active)")
+					continue
+				}
+				colorterm.Info(" -", p)
+			}
+		}
+		colorterm.None("Usage: blade run [--no-validate] [--kill-conflicts] [--until-jobs-complete] [--profile=<name>[,<name>...]]")
 		colorterm.None("Or: blade run <name-or-tag> [<name-or-tag> ...]")
+		colorterm.None("Check the configuration: blade validate | blade schema")
+		colorterm.None("                         blade config [--format=json] [--show-origin] [<name-or-tag> ...]")
+		colorterm.None("While running: blade ps [<name> ...] | blade top [<name> ...]")
+		colorterm.None("               blade attach <name> | blade send <name> [text ...]")
+		colorterm.None("               blade restart <name-or-tag> ... | blade stop <name-or-tag> ...")
 		return
 	}
 
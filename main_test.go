@@ -0,0 +1,193 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mertenvg/blade/internal/service"
+	"github.com/mertenvg/blade/internal/service/proc"
+	"github.com/mertenvg/blade/internal/service/watcher"
+)
+
+func TestConfigPaths(t *testing.T) {
+	t.Setenv("BLADE_CONFIG", "")
+	paths, rest := configPaths([]string{"blade", "-f", "/a.yaml", "run", "--config=/b", "api", "--", "-f", "x"})
+	if !reflect.DeepEqual(paths, []string{"/a.yaml", "/b"}) {
+		t.Errorf("unexpected paths %v", paths)
+	}
+	if !reflect.DeepEqual(rest, []string{"blade", "run", "api", "--", "-f", "x"}) {
+		t.Errorf("unexpected args %v", rest)
+	}
+
+	paths, rest = configPaths([]string{"blade", "--profile", "ci", "send", "-f=/a.yaml", "svc", "-f", "foo"})
+	if !reflect.DeepEqual(paths, []string{"/a.yaml"}) {
+		t.Errorf("expected only the flag before the arguments of send, got %v", paths)
+	}
+	if !reflect.DeepEqual(rest, []string{"blade", "--profile", "ci", "send", "svc", "-f", "foo"}) {
+		t.Errorf("expected the arguments of send to be kept, got %v", rest)
+	}
+
+	paths, rest = configPaths([]string{"blade", "config", "api", "-f", "/a.yaml"})
+	if !reflect.DeepEqual(paths, []string{"/a.yaml"}) || !reflect.DeepEqual(rest, []string{"blade", "config", "api"}) {
+		t.Errorf("expected the flag after the services to be extracted, got %v and %v", paths, rest)
+	}
+
+	t.Setenv("BLADE_CONFIG", "/c.yaml"+string(os.PathListSeparator)+"/d")
+	paths, _ = configPaths([]string{"blade", "run"})
+	if !reflect.DeepEqual(paths, []string{"/c.yaml", "/d"}) {
+		t.Errorf("unexpected paths from BLADE_CONFIG %v", paths)
+	}
+}
+
+func TestFindRoot_SearchesUpward(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "services", "api")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, ".blade"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(sub)
+
+	got, err := FindRoot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := filepath.EvalSymlinks(root)
+	if got, _ = filepath.EvalSymlinks(got); got != want {
+		t.Fatalf("expected root %s, got %s", want, got)
+	}
+}
+
+func TestRebase(t *testing.T) {
+	path := "src"
+	s := &service.S{Watch: &watcher.W{FS: &watcher.FSWatcherConfig{Path: &path, Paths: []string{"lib", "/abs"}}}}
+	rebase(s, "services/api")
+	if s.Dir != "services/api" {
+		t.Errorf("expected empty dir to become the config dir, got %q", s.Dir)
+	}
+	if *s.Watch.FS.Path != "services/api/src" || !reflect.DeepEqual(s.Watch.FS.Paths, []string{"services/api/lib", "/abs"}) {
+		t.Errorf("unexpected watch paths %q %v", *s.Watch.FS.Path, s.Watch.FS.Paths)
+	}
+
+	s = &service.S{Dir: "${HOME}/src"}
+	rebase(s, "services/api")
+	if s.Dir != "${HOME}/src" {
+		t.Errorf("expected a dir starting with a variable to be kept, got %q", s.Dir)
+	}
+}
+
+func TestInheritRecursive_MultipleParents(t *testing.T) {
+	base := &service.S{Name: "base", Sleep: 1, Env: []service.EnvValue{{Name: "A", Value: strPtr("base")}}}
+	golang := &service.S{Name: "go", From: service.Parents{"base"}, Run: "go run .", Dir: "go", Env: []service.EnvValue{{Name: "A", Value: strPtr("go")}}}
+	db := &service.S{Name: "db", From: service.Parents{"base"}, Dir: "db", Env: []service.EnvValue{{Name: "DB", Value: strPtr("db")}}}
+	api := &service.S{Name: "api", From: service.Parents{"go", "db"}}
+	lookup := map[string]*service.S{"base": base, "go": golang, "db": db, "api": api}
+
+	if err := InheritRecursive(api, lookup, make(map[*service.S]bool), nil); err != nil {
+		t.Fatal(err)
+	}
+	if api.Run != "go run ." || api.Dir != "db" || api.Sleep != 1 {
+		t.Errorf("expected run from go, dir from db, the later parent, and sleep from base, got %q %q %d", api.Run, api.Dir, api.Sleep)
+	}
+	if err := ResolveEnv(api); err != nil {
+		t.Fatal(err)
+	}
+	env := make(map[string]string)
+	for _, e := range api.Env {
+		env[e.Name] = *e.Value
+	}
+	if env["A"] != "base" || env["DB"] != "db" {
+		// db inherits A=base and, being the later parent, overrides go's A=go
+		t.Errorf("unexpected env %v", env)
+	}
+	if got := fromChain(api, lookup); !reflect.DeepEqual(got, []string{"db", "base", "go"}) {
+		t.Errorf("unexpected precedence %v", got)
+	}
+}
+
+func TestInheritRecursive_Cycle(t *testing.T) {
+	a := &service.S{Name: "a", From: service.Parents{"b"}}
+	b := &service.S{Name: "b", From: service.Parents{"c"}}
+	c := &service.S{Name: "c", From: service.Parents{"a"}}
+	lookup := map[string]*service.S{"a": a, "b": b, "c": c}
+
+	err := InheritRecursive(a, lookup, make(map[*service.S]bool), nil)
+	if err == nil || err.Error() != "inheritance cycle: a -> b -> c -> a" {
+		t.Fatalf("expected a cycle error, got %v", err)
+	}
+}
+
+func TestExpandReplicas(t *testing.T) {
+	worker := &service.S{Name: "worker", Tags: []string{"queue"}, Replicas: 3, Ports: []service.Port{{Name: "metrics"}}}
+	api := &service.S{Name: "api", Tags: []string{"queue"}}
+	conf := []*service.S{api, worker}
+	services := map[string]*service.S{"api": api, "worker": worker}
+	groups := map[string][]*service.S{"queue": {api, worker}}
+
+	conf, err := expandReplicas(conf, services, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.AllocatePorts(conf); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	ports := make(map[int]bool)
+	for _, s := range conf {
+		names = append(names, s.Name)
+		for _, p := range s.Ports {
+			ports[p.Port] = true
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"api", "worker#1", "worker#2", "worker#3"}) {
+		t.Fatalf("unexpected services %v", names)
+	}
+	if len(ports) != 3 {
+		t.Errorf("expected every replica to get a port of its own, got %v", ports)
+	}
+
+	for _, tc := range []struct {
+		tokens []string
+		names  []string
+	}{
+		{[]string{"worker"}, []string{"worker#1", "worker#2", "worker#3"}},
+		{[]string{"worker#2"}, []string{"worker#2"}},
+		{[]string{"queue"}, []string{"api", "worker#1", "worker#2", "worker#3"}},
+	} {
+		selected, err := selectServices(tc.tokens, services, groups, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, s := range selected {
+			got = append(got, s.Name)
+		}
+		if !reflect.DeepEqual(got, tc.names) {
+			t.Errorf("%v: expected %v, got %v", tc.tokens, tc.names, got)
+		}
+	}
+
+	clash := &service.S{Name: "cache", Replicas: 2, Source: service.Source{File: "blade.yaml", Line: 3}}
+	services = map[string]*service.S{"cache": clash, "cache#2": {Name: "cache#2", Source: service.Source{File: "blade.yaml", Line: 9}}}
+	if _, err := expandReplicas([]*service.S{clash}, services, nil); err == nil || err.Error() != "blade.yaml:3: replica cache#2 of cache clashes with the service defined at blade.yaml:9" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestIntervalCPU_ResetsAfterRestart(t *testing.T) {
+	prev := service.Snapshot{PID: 10, Usage: proc.Usage{CPUTime: 4 * time.Second}}
+	same := service.Snapshot{PID: 10, Uptime: time.Minute, Usage: proc.Usage{CPUTime: 5 * time.Second}}
+	if got := intervalCPU(same, prev, 2*time.Second); got != 50 {
+		t.Errorf("expected 50%% over the interval, got %v", got)
+	}
+	// the new process used less CPU in total than the old one had
+	restarted := service.Snapshot{PID: 11, Uptime: 2 * time.Second, Usage: proc.Usage{CPUTime: time.Second}}
+	if got := intervalCPU(restarted, prev, 2*time.Second); got != 50 {
+		t.Errorf("expected 50%% over the uptime of the new process, got %v", got)
+	}
+}
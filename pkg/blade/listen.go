@@ -0,0 +1,85 @@
+package blade
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFDsStart is the first fd passed with the systemd socket activation
+// protocol.
+const listenFDsStart = 3
+
+var (
+	listenOnce sync.Once
+	listeners  map[string]net.Listener
+	listenErr  error
+)
+
+// Listeners returns the listeners blade passed to the service for its
+// sockets, by name. It returns an empty map when the service wasn't given
+// any, e.g. when run outside blade. The fds are read on the first call and
+// the LISTEN_* variables removed, so they aren't passed on to processes the
+// service starts; later calls return the same listeners.
+//
+// The sockets are only taken when LISTEN_PID is unset or names the calling
+// process; otherwise they were meant for another process.
+func Listeners() (map[string]net.Listener, error) {
+	listenOnce.Do(func() {
+		listeners, listenErr = parseListeners()
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+	if listenErr != nil {
+		return nil, listenErr
+	}
+	return maps.Clone(listeners), nil
+}
+
+func parseListeners() (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// meant for another process
+		return listeners, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return listeners, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := range count {
+		fd := listenFDsStart + i
+		name := strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		// the listener gets its own close-on-exec copy of the fd
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("blade: socket %s: %w", name, err)
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
+
+// Listen returns the listener blade passed for the socket name, or else
+// listens on address, so the service also runs outside blade. It can be
+// called once for each socket of a service.
+func Listen(name, network, address string) (net.Listener, error) {
+	listeners, err := Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := listeners[name]; ok {
+		return l, nil
+	}
+	return net.Listen(network, address)
+}
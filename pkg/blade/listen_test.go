@@ -0,0 +1,196 @@
+package blade_test
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/mertenvg/blade/pkg/blade"
+)
+
+// TestHelperListener is run as a subprocess by TestListen_PassedSocket. It
+// answers one connection on the socket blade would have passed.
+func TestHelperListener(t *testing.T) {
+	if os.Getenv("BLADE_TEST_HELPER") != "listener" {
+		return
+	}
+	l, err := blade.Listen("http", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("expected LISTEN_FDS to be cleared")
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello from the passed socket\n"))
+	conn.Close()
+}
+
+func TestListen_PassedSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix-only test")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	testBin, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := exec.Command(testBin, "-test.run=^TestHelperListener$")
+	c.Env = append(os.Environ(), "BLADE_TEST_HELPER=listener", "LISTEN_FDS=1", "LISTEN_FDNAMES=http")
+	c.ExtraFiles = []*os.File{f}
+	out := make(chan []byte, 1)
+	go func() {
+		b, _ := c.CombinedOutput()
+		out <- b
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello from the passed socket\n" {
+		t.Errorf("unexpected reply %q, %v", line, err)
+	}
+	if b := <-out; c.ProcessState == nil || !c.ProcessState.Success() {
+		t.Errorf("helper failed:\n%s", b)
+	}
+}
+
+// TestHelperTwoListeners is run as a subprocess by TestListen_TwoSockets. It
+// picks up both sockets with Listen and answers one connection on each.
+func TestHelperTwoListeners(t *testing.T) {
+	if os.Getenv("BLADE_TEST_HELPER") != "two-listeners" {
+		return
+	}
+	// blade's shim sets LISTEN_PID to its own pid and execs the command in
+	// place, so the service finds its own pid there
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	for _, name := range []string{"http", "grpc"} {
+		l, err := blade.Listen(name, "tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("hello from " + name + "\n"))
+		conn.Close()
+	}
+}
+
+func TestListen_TwoSockets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix-only test")
+	}
+	var addrs []string
+	var files []*os.File
+	for range 2 {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		addrs = append(addrs, l.Addr().String())
+		files = append(files, f)
+	}
+
+	testBin, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := exec.Command(testBin, "-test.run=^TestHelperTwoListeners$")
+	c.Env = append(os.Environ(), "BLADE_TEST_HELPER=two-listeners", "LISTEN_FDS=2", "LISTEN_FDNAMES=http:grpc")
+	c.ExtraFiles = files
+	out := make(chan []byte, 1)
+	go func() {
+		b, _ := c.CombinedOutput()
+		out <- b
+	}()
+
+	for i, name := range []string{"http", "grpc"} {
+		conn, err := net.Dial("tcp", addrs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil || line != "hello from "+name+"\n" {
+			t.Errorf("unexpected reply on %s %q, %v", name, line, err)
+		}
+	}
+	if b := <-out; c.ProcessState == nil || !c.ProcessState.Success() {
+		t.Errorf("helper failed:\n%s", b)
+	}
+}
+
+// TestHelperParentPid is run as a subprocess by TestListeners_ParentPid. It
+// expects the sockets meant for its parent to be left alone.
+func TestHelperParentPid(t *testing.T) {
+	if os.Getenv("BLADE_TEST_HELPER") != "parent-pid" {
+		return
+	}
+	listeners, err := blade.Listeners()
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("expected no listeners, got %v, %v", listeners, err)
+	}
+}
+
+func TestListeners_ParentPid(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix-only test")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	testBin, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := exec.Command(testBin, "-test.run=^TestHelperParentPid$")
+	c.Env = append(os.Environ(), "BLADE_TEST_HELPER=parent-pid", "LISTEN_PID="+strconv.Itoa(os.Getpid()), "LISTEN_FDS=1", "LISTEN_FDNAMES=http")
+	c.ExtraFiles = []*os.File{f}
+	if b, err := c.CombinedOutput(); err != nil {
+		t.Errorf("helper failed: %v\n%s", err, b)
+	}
+}
+
+func TestListeners_NotPassed(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "")
+	listeners, err := blade.Listeners()
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("expected no listeners, got %v, %v", listeners, err)
+	}
+}
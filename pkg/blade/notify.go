@@ -0,0 +1,27 @@
+package blade
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// Ready tells blade that the service is ready to take connections, for
+// services with a notify readiness probe. It follows systemd's sd_notify, so
+// it works under systemd too, and does nothing when the service wasn't asked
+// to report readiness, e.g. when run outside blade.
+func Ready() error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		return fmt.Errorf("blade: notify: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("READY=1")); err != nil {
+		return fmt.Errorf("blade: notify: %w", err)
+	}
+	return nil
+}
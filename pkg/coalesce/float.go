@@ -0,0 +1,10 @@
+package coalesce
+
+func Float64(args ...float64) float64 {
+	for _, f := range args {
+		if f != 0 {
+			return f
+		}
+	}
+	return 0
+}
@@ -8,3 +8,12 @@ func Int(args ...int) int {
 	}
 	return 0
 }
+
+func Uint64(args ...uint64) uint64 {
+	for _, i := range args {
+		if i != 0 {
+			return i
+		}
+	}
+	return 0
+}
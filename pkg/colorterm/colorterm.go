@@ -1,6 +1,10 @@
 package colorterm
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/mertenvg/blade/pkg/redact"
+)
 
 type color string
 
@@ -42,13 +46,13 @@ func (ct *CT) Print(c color, a ...any) *CT {
 }
 
 func (ct *CT) Printf(c color, format string, a ...any) *CT {
-	fmt.Println(ct.Sprintf(c, format, a...))
+	fmt.Println(redact.String(ct.Sprintf(c, format, a...)))
 	return ct
 }
 
 func (ct *CT) Println(c color, a ...any) *CT {
 	fmt.Print(c)
-	fmt.Println(a...)
+	fmt.Print(redact.String(fmt.Sprintln(a...)))
 	fmt.Print(ColorNone)
 	return ct
 }
@@ -0,0 +1,118 @@
+// Package redact masks secret values in text blade prints or passes on from
+// the services it runs.
+package redact
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Mask replaces secret values.
+const Mask = "****"
+
+var (
+	mu      sync.RWMutex
+	secrets []string
+	// expected is set when secrets will be registered later
+	expected bool
+)
+
+// Expect makes Active report true before any secret is registered, for
+// secrets that are only known once they are read, e.g. when a service starts.
+func Expect() {
+	mu.Lock()
+	defer mu.Unlock()
+	expected = true
+}
+
+// Active reports whether there are secrets to mask, registered or expected.
+func Active() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return expected || len(secrets) > 0
+}
+
+// Add registers values to be masked from now on. Empty values are ignored.
+func Add(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, v := range values {
+		if v != "" && !slices.Contains(secrets, v) {
+			secrets = append(secrets, v)
+		}
+	}
+	// longest first, so a secret containing another is masked as a whole
+	slices.SortStableFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+}
+
+// String returns s with every registered secret replaced by Mask.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return replace(s)
+}
+
+func replace(s string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	return s
+}
+
+// Writer masks secrets in everything written through it. The end of a write
+// that could be the start of a secret is held back until the next write shows
+// whether it is, or until Flush.
+type Writer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	pending string
+}
+
+// NewWriter returns a Writer passing masked output on to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	mu.RLock()
+	buf := w.pending + string(p)
+	n := len(buf) - held(buf)
+	out := replace(buf[:n])
+	mu.RUnlock()
+
+	w.pending = buf[n:]
+	if _, err := io.WriteString(w.w, out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes out anything held back.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := String(w.pending)
+	w.pending = ""
+	_, err := io.WriteString(w.w, out)
+	return err
+}
+
+// held returns the length of the longest end of buf that a secret starts
+// with, without being all of the secret.
+func held(buf string) int {
+	longest := 0
+	for _, secret := range secrets {
+		for k := min(len(secret)-1, len(buf)); k > longest; k-- {
+			if strings.HasPrefix(secret, buf[len(buf)-k:]) {
+				longest = k
+				break
+			}
+		}
+	}
+	return longest
+}
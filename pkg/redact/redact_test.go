@@ -0,0 +1,38 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	Add("hunter2", "", "hunter2-long")
+	if got := String("pass=hunter2 or hunter2-long"); got != "pass=**** or ****" {
+		t.Errorf("unexpected %q", got)
+	}
+}
+
+func TestWriter_SplitSecret(t *testing.T) {
+	Add("s3cr3t-token")
+	var b strings.Builder
+	w := NewWriter(&b)
+	for _, chunk := range []string{"token: s3c", "r3t-to", "ken\nprompt> s3", "x"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := b.String(); got != "token: ****\nprompt> s3x" {
+		t.Errorf("unexpected output %q", got)
+	}
+
+	w.Write([]byte("end s3cr"))
+	if got := b.String(); strings.HasSuffix(got, "s3cr") {
+		t.Errorf("expected a possible secret to be held back, got %q", got)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); !strings.HasSuffix(got, "end s3cr") {
+		t.Errorf("expected flush to write the held back text, got %q", got)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mertenvg/blade/internal/service"
+)
+
+// ApplyProfiles merges the overlays of each active profile, in order, into
+// the services they name, and adds the services a profile defines that don't
+// exist otherwise. An overlay is merged with service.S.Overlay, so set values
+// replace the service's, env and tags are appended, and booleans set
+// explicitly in the overlay, including to false, win.
+// Overlays of inactive profiles are dropped.
+func ApplyProfiles(items []configItem, active []string) ([]configItem, error) {
+	var out []configItem
+	overlays := make(map[string][]configItem)
+	for _, item := range items {
+		if item.Profile == "" {
+			out = append(out, item)
+			continue
+		}
+		overlays[item.Profile] = append(overlays[item.Profile], item)
+	}
+
+	for _, profile := range active {
+		list, ok := overlays[profile]
+		if !ok {
+			known := profileNames(items)
+			if len(known) == 0 {
+				return nil, fmt.Errorf("unknown profile '%s', no profiles are defined", profile)
+			}
+			return nil, fmt.Errorf("unknown profile '%s', expected one of: %s%s", profile, strings.Join(known, ", "), didYouMean(profile, known))
+		}
+		for _, overlay := range list {
+			if overlay.Invalid {
+				continue
+			}
+			i := slices.IndexFunc(out, func(item configItem) bool { return item.Name == overlay.Name })
+			if i < 0 {
+				added := overlay
+				added.S.Profiles = []string{profile}
+				added.Profile = ""
+				out = append(out, added)
+				continue
+			}
+			out[i].S = overlayService(out[i].S, overlay, profile)
+		}
+	}
+	return out, nil
+}
+
+// profileNames lists the profiles defined in items.
+func profileNames(items []configItem) []string {
+	var names []string
+	for _, item := range items {
+		if item.Profile != "" && !slices.Contains(names, item.Profile) {
+			names = append(names, item.Profile)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func overlayService(base *service.S, overlay configItem, profile string) *service.S {
+	o := overlay.S
+	merged := base
+	merged.Overlay(o)
+	if len(o.From) > 0 {
+		merged.From = o.From
+	}
+	merged.Profiles = append(slices.Clone(base.Profiles), profile)
+
+	// Overlay ORs booleans, which would make false impossible to set
+	explicit := func(key string, field *bool, value bool) {
+		if mappingValue(overlay.Node, key) != nil {
+			*field = value
+		}
+	}
+	explicit("skip", &merged.Skip, o.Skip)
+	explicit("dnr", &merged.DNR, o.DNR)
+	explicit("inheritEnv", &merged.InheritEnv, o.InheritEnv)
+	explicit("tty", &merged.TTY, o.TTY)
+	explicit("lazy", &merged.Lazy, o.Lazy)
+	explicit("abstract", &merged.Abstract, o.Abstract)
+	return merged
+}
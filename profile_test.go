@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyProfiles(t *testing.T) {
+	files := []configFile{{Path: "blade.yaml", Data: []byte(`
+services:
+  - name: api
+    run: ./api --mock-db
+    watch:
+      fs:
+        path: api
+    env:
+      - name: DB_URL
+        value: mock://
+  - name: mock-db
+    run: ./mock-db
+profiles:
+  integration:
+    - name: api
+      run: ./api
+      env:
+        - name: DB_URL
+          value: postgres://staging
+    - name: mock-db
+      skip: true
+    - name: tunnel
+      run: ./tunnel
+  debug:
+    - name: api
+      dnr: true
+`)}}
+	parsed, errs, _ := parseConfig(files)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	if got := profileNames(parsed); !reflect.DeepEqual(got, []string{"debug", "integration"}) {
+		t.Errorf("unexpected profile names %v", got)
+	}
+
+	if _, err := ApplyProfiles(parsed, []string{"integraton"}); err == nil || !strings.Contains(err.Error(), "did you mean 'integration'?") {
+		t.Errorf("expected a suggestion for an unknown profile, got %v", err)
+	}
+
+	items, err := ApplyProfiles(parsed, []string{"integration", "debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("expected api, mock-db and tunnel, got %d services", len(items))
+	}
+	api, db, tunnel := items[0], items[1], items[2]
+	if api.Run != "./api" || !api.DNR || api.Watch == nil || *api.Watch.FS.Path != "api" {
+		t.Errorf("unexpected api %+v", api.S)
+	}
+	if last := api.Env[len(api.Env)-1]; *last.Value != "postgres://staging" {
+		t.Errorf("expected the profile's env value to come last, got %q", *last.Value)
+	}
+	if !reflect.DeepEqual(api.Profiles, []string{"integration", "debug"}) || api.Source.Line != 3 {
+		t.Errorf("unexpected profiles %v or source %s", api.Profiles, api.Source)
+	}
+	if !db.Skip || db.Run != "./mock-db" {
+		t.Errorf("unexpected mock-db %+v", db.S)
+	}
+	if tunnel.Name != "tunnel" || tunnel.Profile != "" {
+		t.Errorf("expected tunnel to be added as a service, got %+v", tunnel)
+	}
+}
+
+func TestApplyProfiles_ExplicitFalse(t *testing.T) {
+	files := []configFile{{Path: "blade.yaml", Data: []byte(`
+services:
+  - name: seed
+    skip: true
+    run: ./seed
+profiles:
+  ci:
+    - name: seed
+      skip: false
+`)}}
+	items, _, _ := parseConfig(files)
+	items, err := ApplyProfiles(items, []string{"ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[0].Skip {
+		t.Errorf("expected skip: false in the profile to override skip: true")
+	}
+}
+
+func TestActiveProfiles(t *testing.T) {
+	t.Setenv("BLADE_PROFILE", "from-env")
+	profiles, rest := activeProfiles([]string{"blade", "run", "--profile=a,b", "api", "--profile", "a"})
+	if !reflect.DeepEqual(profiles, []string{"a", "b"}) || !reflect.DeepEqual(rest, []string{"blade", "run", "api"}) {
+		t.Errorf("unexpected profiles %v and args %v", profiles, rest)
+	}
+	if profiles, _ := activeProfiles([]string{"blade", "run"}); !reflect.DeepEqual(profiles, []string{"from-env"}) {
+		t.Errorf("expected BLADE_PROFILE to be used, got %v", profiles)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mertenvg/blade/internal/proxy"
+	"github.com/mertenvg/blade/internal/service"
+)
+
+// ParseProxy decodes the proxy: section of the configuration. Only one of the
+// files blade was started with may have one; included files can't.
+func ParseProxy(files []configFile) (*proxy.Config, []configError) {
+	var cfg *proxy.Config
+	var first string
+	var errs []configError
+	for _, f := range files {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(f.Data, &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			// syntax errors are reported by parseConfig
+			continue
+		}
+		node := mappingValue(doc.Content[0], "proxy")
+		if node == nil {
+			continue
+		}
+		if cfg != nil {
+			errs = append(errs, configError{f.Path, node.Line, fmt.Sprintf("proxy: already defined in %s", first)})
+			continue
+		}
+		errs = append(errs, checkKeys(f.Path, node, reflect.TypeOf(proxy.Config{}), "proxy.")...)
+		cfg, first = &proxy.Config{}, f.Path
+		if err := node.Decode(cfg); err != nil {
+			errs = append(errs, yamlErrors(f.Path, err)...)
+		}
+	}
+	return cfg, errs
+}
+
+// newProxy sets up the proxy for cfg, routing to the services that aren't
+// abstract. A service with replicas is only known by the names of its
+// replicas, as the proxy doesn't balance between them.
+func newProxy(cfg proxy.Config, conf []*service.S) (*proxy.Proxy, error) {
+	services := make(map[string]*service.S, len(conf))
+	replicas := make(map[string][]string)
+	for _, s := range conf {
+		services[s.Name] = s
+		if s.ReplicaOf != "" {
+			replicas[s.ReplicaOf] = append(replicas[s.ReplicaOf], s.Name)
+		}
+	}
+	for i, r := range cfg.Routes {
+		if set := replicas[r.Service]; len(set) > 0 {
+			return nil, fmt.Errorf("proxy: route %d: service '%s' runs as %d replicas, route to one of them, e.g. '%s'", i+1, r.Service, len(set), set[0])
+		}
+		if _, ok := services[r.Service]; r.Service != "" && !ok {
+			names := slices.Sorted(maps.Keys(services))
+			return nil, fmt.Errorf("proxy: route %d: unknown service '%s'%s", i+1, r.Service, didYouMean(r.Service, names))
+		}
+	}
+	return proxy.New(cfg, services)
+}
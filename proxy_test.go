@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mertenvg/blade/internal/proxy"
+	"github.com/mertenvg/blade/internal/service"
+)
+
+func TestParseProxy(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+	writeFile(t, "blade.yaml", `
+include: [web.yaml]
+proxy:
+  listen: localhost:8080
+  routes:
+    - host: api.localhost
+      service: api
+      prot: http
+services:
+  - name: api
+    run: ./api
+    ports:
+      - name: http
+`)
+	writeFile(t, "web.yaml", "proxy:\n  listen: localhost:9090\n")
+
+	files := LoadConfig(nil)
+	cfg, errs := ParseProxy(files)
+	if cfg == nil || cfg.Listen != "localhost:8080" || len(cfg.Routes) != 1 || cfg.Routes[0].Service != "api" {
+		t.Fatalf("unexpected proxy config %+v", cfg)
+	}
+	if len(errs) != 1 || errs[0].String() != "blade.yaml:8: unknown key 'proxy.routes.prot', did you mean 'port'?" {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	_, errs, _ = parseConfig(files)
+	if len(errs) != 1 || errs[0].String() != "web.yaml:1: proxy: only allowed in the top-level configuration, not in included files" {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	_, err := newProxy(*cfg, []*service.S{{Name: "apu"}})
+	if err == nil || !strings.HasSuffix(err.Error(), "unknown service 'api', did you mean 'apu'?") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestNewProxy_RouteToReplicas(t *testing.T) {
+	worker := &service.S{Name: "worker", Replicas: 2, Ports: []service.Port{{Name: "http"}}}
+	services := map[string]*service.S{"worker": worker}
+	conf, err := expandReplicas([]*service.S{worker}, services, make(map[string][]*service.S))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := proxy.Config{Listen: "localhost:8080", Routes: []proxy.Route{{Host: "worker.localhost", Service: "worker"}}}
+	_, err = newProxy(cfg, conf)
+	if err == nil || err.Error() != "proxy: route 1: service 'worker' runs as 2 replicas, route to one of them, e.g. 'worker#1'" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	cfg.Routes[0].Service = "worker#2"
+	if _, err := newProxy(cfg, conf); err != nil {
+		t.Errorf("expected a route to a replica, got %v", err)
+	}
+}
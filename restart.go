@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+
+	"github.com/mertenvg/blade/internal/control"
+	"github.com/mertenvg/blade/internal/service"
+	"github.com/mertenvg/blade/pkg/colorterm"
+)
+
+// lifecycleHandler answers a control command that applies action to every
+// service named by the arguments: a service, one of its replicas like
+// worker#1, a service with replicas for all of them, or a tag. It replies
+// with the names of the services acted on.
+func lifecycleHandler(services map[string]*service.S, groups map[string][]*service.S, action func(*service.S)) control.HandlerFunc {
+	return func(req control.Request) (any, error) {
+		selected, err := selectServices(req.Args, services, groups, false)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, len(selected))
+		for i, s := range selected {
+			action(s)
+			names[i] = s.Name
+		}
+		return names, nil
+	}
+}
+
+// restart restarts the named services of a running blade, or stops them for
+// the rest of the run with command "stop".
+func restart(command string, args []string) {
+	if len(args) == 0 {
+		colorterm.None("Usage: blade", command, "<name-or-tag> [<name-or-tag> ...]")
+		os.Exit(1)
+	}
+	var names []string
+	if err := control.Call(control.SocketPath("."), control.Request{Command: command, Args: args}, &names); err != nil {
+		exitControlError(err)
+	}
+	for _, name := range names {
+		colorterm.Success(name, command+" requested")
+	}
+}
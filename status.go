@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mertenvg/blade/internal/control"
+	"github.com/mertenvg/blade/internal/service"
+	"github.com/mertenvg/blade/internal/service/proc"
+	"github.com/mertenvg/blade/pkg/colorterm"
+)
+
+// topInterval is how often `blade top` refreshes.
+const topInterval = 2 * time.Second
+
+// statusHandler answers the "status" control command with a snapshot of each
+// requested service, or of every service when no names are given. A name may
+// also be a tag, or a service with replicas to show all of them.
+func statusHandler(services map[string]*service.S, groups map[string][]*service.S, conf []*service.S) control.HandlerFunc {
+	return func(req control.Request) (any, error) {
+		selected := conf
+		if len(req.Args) > 0 {
+			var err error
+			if selected, err = selectServices(req.Args, services, groups, false); err != nil {
+				return nil, err
+			}
+		}
+		var snaps []service.Snapshot
+		for _, s := range selected {
+			snaps = append(snaps, s.Snapshot())
+		}
+		return snaps, nil
+	}
+}
+
+func fetchStatus(names []string) ([]service.Snapshot, error) {
+	var snaps []service.Snapshot
+	err := control.Call(control.SocketPath("."), control.Request{Command: "status", Args: names}, &snaps)
+	return snaps, err
+}
+
+// ps prints a one-off table of services with their process trees. CPU is
+// averaged over the lifetime of the service.
+func ps(names []string) {
+	snaps, err := fetchStatus(names)
+	if err != nil {
+		exitControlError(err)
+	}
+	printStatusTable(snaps, nil, 0, true)
+}
+
+// top redraws the status table every topInterval, showing CPU usage over the
+// last interval, until interrupted.
+func top(names []string) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	ticker := time.NewTicker(topInterval)
+	defer ticker.Stop()
+
+	var prev map[string]service.Snapshot
+	var prevAt time.Time
+	for {
+		snaps, err := fetchStatus(names)
+		if err != nil {
+			exitControlError(err)
+		}
+		now := time.Now()
+		sort.SliceStable(snaps, func(i, j int) bool {
+			return snaps[i].Usage.RSS > snaps[j].Usage.RSS
+		})
+
+		// clear screen and move the cursor home
+		fmt.Print("\x1b[H\x1b[2J")
+		colorterm.Infof("blade top — %s (refresh %s, ctrl+c to quit)", now.Format(time.TimeOnly), topInterval)
+		printStatusTable(snaps, prev, now.Sub(prevAt), false)
+
+		prev = make(map[string]service.Snapshot, len(snaps))
+		for _, s := range snaps {
+			prev[s.Name] = s
+		}
+		prevAt = now
+
+		select {
+		case <-sig:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func exitControlError(err error) {
+	if errors.Is(err, control.ErrNotRunning) {
+		colorterm.Error("Couldn't reach blade: is `blade run` active in this project?")
+	} else {
+		colorterm.Error(err)
+	}
+	os.Exit(1)
+}
+
+// printStatusTable prints one row per service, with CPU% as returned by
+// intervalCPU.
+func printStatusTable(snaps []service.Snapshot, prev map[string]service.Snapshot, elapsed time.Duration, tree bool) {
+	colorterm.Nonef("%-24s %8s %7s %10s %5s %5s %5s  %-16s  %s", "NAME", "PID", "CPU%", "RSS", "THR", "FDS", "PROCS", "PORTS", "STATE")
+	for _, s := range snaps {
+		cpu := intervalCPU(s, prev[s.Name], elapsed)
+		row := fmt.Sprintf("%-24s %8s %7.1f %10s %5d %5d %5d  %-16s  %s",
+			s.Name, pidString(s.PID), cpu, formatBytes(s.Usage.RSS), s.Usage.Threads, s.Usage.FDs, s.Usage.Processes, portsString(s.Ports), s.State)
+		if s.Active {
+			colorterm.Success(row)
+		} else {
+			colorterm.Error(row)
+		}
+		if tree && s.Tree != nil {
+			printTree(s.Tree, s.Uptime)
+		}
+	}
+}
+
+func printTree(root *proc.Process, uptime time.Duration) {
+	root.Walk(func(p *proc.Process, depth int) {
+		colorterm.Nonef("  %s└─ %d %s  cpu %.1f%%  rss %s  thr %d  fds %d",
+			strings.Repeat("   ", depth), p.PID, truncate(p.Command, 60),
+			cpuPercent(p.CPUTime, uptime), formatBytes(p.RSS), p.Threads, p.FDs)
+	})
+}
+
+// printStatusDump is the SIGINFO status snapshot printed by `blade run`.
+func printStatusDump(conf []*service.S) {
+	for _, s := range conf {
+		snap := s.Snapshot()
+		if !snap.Active {
+			colorterm.Error(s.Name, pidString(snap.PID), snap.State)
+			continue
+		}
+		line := []any{s.Name, pidString(snap.PID), snap.State}
+		if len(snap.Ports) > 0 {
+			line = append(line, "ports "+portsString(snap.Ports))
+		}
+		colorterm.Success(append(line,
+			fmt.Sprintf("cpu %.1f%% rss %s threads %d fds %d procs %d",
+				cpuPercent(snap.Usage.CPUTime, snap.Uptime), formatBytes(snap.Usage.RSS),
+				snap.Usage.Threads, snap.Usage.FDs, snap.Usage.Processes))...)
+	}
+}
+
+// portsString lists ports as name:port, or "-" when there are none.
+func portsString(ports []service.Port) string {
+	if len(ports) == 0 {
+		return "-"
+	}
+	list := make([]string, len(ports))
+	for i, p := range ports {
+		list[i] = p.String()
+	}
+	return strings.Join(list, ",")
+}
+
+// intervalCPU returns the CPU% of s over the elapsed time since prev, a
+// sample of the same process. Without one, e.g. after a restart, it is
+// averaged over the uptime of s.
+func intervalCPU(s, prev service.Snapshot, elapsed time.Duration) float64 {
+	if prev.PID == 0 || prev.PID != s.PID || elapsed <= 0 {
+		return cpuPercent(s.Usage.CPUTime, s.Uptime)
+	}
+	return cpuPercent(s.Usage.CPUTime-prev.Usage.CPUTime, elapsed)
+}
+
+func cpuPercent(cpu, wall time.Duration) float64 {
+	if wall <= 0 {
+		return 0
+	}
+	return float64(cpu) / float64(wall) * 100
+}
+
+func pidString(pid int) string {
+	if pid == 0 {
+		return "-"
+	}
+	return fmt.Sprint(pid)
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "…"
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mertenvg/blade/internal/service"
+)
+
+// configEntry is the position and the fields of a service that validation
+// needs to cross-check services against each other.
+type configEntry struct {
+	file     string
+	line     int
+	name     string
+	from     []string
+	fromLine int
+	run      string
+	skip     bool
+	abstract bool
+}
+
+// schema is the JSON Schema for blade configuration files, for editors and
+// CI to validate against.
+//
+//go:embed blade.schema.json
+var schema []byte
+
+func printSchema() {
+	os.Stdout.Write(schema)
+}
+
+var serviceType = reflect.TypeOf(service.S{})
+
+// validateKeys reports keys that don't exist in the parsed services,
+// including the overlays of profiles that aren't active.
+func validateKeys(items []configItem) []configError {
+	var errs []configError
+	for _, item := range items {
+		errs = append(errs, checkKeys(item.Source.File, item.Node, serviceType, "")...)
+	}
+	return errs
+}
+
+// validateServices checks the services, once profiles are applied, against
+// each other for duplicate names, unknown `from:` parents and missing `run:`.
+func validateServices(items []configItem) []configError {
+	entries := make([]configEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, configEntry{
+			file:     item.Source.File,
+			line:     item.Source.Line,
+			name:     item.Name,
+			from:     item.From,
+			fromLine: valueLine(item.Node, "from"),
+			run:      item.Run,
+			skip:     item.Skip,
+			abstract: item.Abstract,
+		})
+	}
+	return checkEntries(entries)
+}
+
+func checkEntries(entries []configEntry) []configError {
+	var errs []configError
+
+	byName := make(map[string]configEntry)
+	parents := make(map[string]bool)
+	for _, e := range entries {
+		for _, from := range e.from {
+			parents[from] = true
+		}
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.name == "" {
+			errs = append(errs, configError{e.file, e.line, "service is missing 'name'"})
+			continue
+		}
+		if first, ok := byName[e.name]; ok {
+			errs = append(errs, configError{e.file, e.line, fmt.Sprintf("duplicate service '%s', first defined at %s:%d", e.name, first.file, first.line)})
+			continue
+		}
+		byName[e.name] = e
+		names = append(names, e.name)
+	}
+
+	for _, e := range entries {
+		for _, from := range e.from {
+			if _, ok := byName[from]; !ok {
+				errs = append(errs, configError{e.file, e.fromLine, fmt.Sprintf("from: unknown service '%s'%s", from, didYouMean(from, names))})
+			}
+		}
+	}
+
+	errs = append(errs, checkCycles(names, byName)...)
+
+	for _, e := range entries {
+		// templates, and parents and skipped services that often act as
+		// templates, may leave run empty
+		if e.name == "" || e.abstract || e.skip || parents[e.name] {
+			continue
+		}
+		if !hasRun(e, byName, make(map[string]bool)) {
+			errs = append(errs, configError{e.file, e.line, fmt.Sprintf("service '%s' has no 'run' command", e.name)})
+		}
+	}
+	return errs
+}
+
+// hasRun reports whether e or any service it inherits from has a run command.
+func hasRun(e configEntry, byName map[string]configEntry, seen map[string]bool) bool {
+	if e.run != "" {
+		return true
+	}
+	seen[e.name] = true
+	for _, from := range e.from {
+		if parent, ok := byName[from]; ok && !seen[from] && hasRun(parent, byName, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCycles reports every from: cycle once, at the service that closes it.
+func checkCycles(names []string, byName map[string]configEntry) []configError {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	var errs []configError
+	state := make(map[string]int)
+	var stack []string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		e := byName[name]
+		for _, from := range e.from {
+			if _, ok := byName[from]; !ok {
+				continue
+			}
+			switch state[from] {
+			case visiting:
+				cycle := append(slices.Clone(stack[slices.Index(stack, from):]), from)
+				errs = append(errs, configError{e.file, e.fromLine, "from: inheritance cycle: " + strings.Join(cycle, " -> ")})
+			case 0:
+				visit(from)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+	for _, name := range names {
+		if state[name] == 0 {
+			visit(name)
+		}
+	}
+	return errs
+}
+
+// checkKeys reports mapping keys that don't correspond to a yaml field of t,
+// recursing into nested structs, slices and pointers.
+func checkKeys(file string, node *yaml.Node, t reflect.Type, path string) []configError {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		if t.Kind() == reflect.Slice {
+			if node.Kind != yaml.SequenceNode {
+				return nil
+			}
+			var errs []configError
+			for _, item := range node.Content {
+				errs = append(errs, checkKeys(file, item, t.Elem(), path)...)
+			}
+			return errs
+		}
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || node.Kind != yaml.MappingNode || implementsUnmarshaler(t) {
+		return nil
+	}
+
+	fields := yamlFields(t)
+	var errs []configError
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		ft, ok := fields[key.Value]
+		if !ok {
+			names := make([]string, 0, len(fields))
+			for n := range fields {
+				names = append(names, n)
+			}
+			errs = append(errs, configError{file, key.Line, fmt.Sprintf("unknown key '%s%s'%s", path, key.Value, didYouMean(key.Value, names))})
+			continue
+		}
+		errs = append(errs, checkKeys(file, value, ft, path+key.Value+".")...)
+	}
+	return errs
+}
+
+// yamlFields maps the yaml key of every exported field of t to its type.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func implementsUnmarshaler(t reflect.Type) bool {
+	u := reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	return t.Implements(u) || reflect.PointerTo(t).Implements(u)
+}
+
+// valueLine returns the line of the value for key in a mapping node, or the
+// line of the mapping itself when key is absent.
+func valueLine(node *yaml.Node, key string) int {
+	if value := mappingValue(node, key); value != nil {
+		return value.Line
+	}
+	return node.Line
+}
+
+// didYouMean suggests the closest of options to s, if any is close enough to
+// plausibly be a typo.
+func didYouMean(s string, options []string) string {
+	best, bestDist := "", 3
+	for _, o := range options {
+		if d := levenshtein(strings.ToLower(s), strings.ToLower(o)); d < bestDist {
+			best, bestDist = o, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean '%s'?", best)
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// checkConfig runs the same checks as `blade validate`.
+func checkConfig(files []configFile) []configError {
+	items, errs, _ := parseConfig(files)
+	errs = append(errs, validateKeys(items)...)
+	errs = append(errs, validateServices(items)...)
+	sortConfigErrors(errs)
+	return errs
+}
+
+func TestParseConfig_ReportsPerFile(t *testing.T) {
+	files := []configFile{
+		{Path: "a.yaml", Data: []byte("- name: api\n  run: [oops\n")},
+		{Path: "b.yaml", Data: []byte("- name: web\n  sleep: soon\n  run: ./web\n- name: db\n  run: ./db\n  env:\n    - name: PORT\n      value: \"5432\"\n")},
+	}
+	items, errs, _ := parseConfig(files)
+	if len(errs) != 2 || errs[0].File != "a.yaml" || errs[1].File != "b.yaml" || errs[1].Line != 2 {
+		t.Fatalf("expected one error per broken file, got %v", errs)
+	}
+	if len(items) != 2 || !items[0].Invalid || items[1].Invalid {
+		t.Fatalf("expected web to be invalid and db to load, got %+v", items)
+	}
+	db := items[1]
+	if db.Source.String() != "b.yaml:4" {
+		t.Errorf("unexpected source %q", db.Source)
+	}
+	if db.Env[0].Origin != "db (b.yaml:7)" {
+		t.Errorf("unexpected env origin %q", db.Env[0].Origin)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	files := []configFile{
+		{Path: "a.yaml", Data: []byte(`
+- name: base
+  env:
+    - name: PORT
+      value: "8080"
+- name: api
+  from: base
+  befor: echo hi
+  run: ./api
+- name: worker
+  from: bsae
+  run: ./worker
+`)},
+		{Path: "b.yaml", Data: []byte(`
+- name: api
+  run: ./api2
+- name: idle
+  sleep: soon
+  limits:
+    memroy: 1G
+`)},
+	}
+
+	var got []string
+	for _, e := range checkConfig(files) {
+		got = append(got, e.String())
+	}
+	want := []string{
+		"a.yaml:8: unknown key 'befor', did you mean 'before'?",
+		"a.yaml:11: from: unknown service 'bsae', did you mean 'base'?",
+		"b.yaml:2: duplicate service 'api', first defined at a.yaml:6",
+		"b.yaml:4: service 'idle' has no 'run' command",
+		"b.yaml:5: cannot unmarshal !!str `soon` into int",
+		"b.yaml:7: unknown key 'limits.memroy', did you mean 'memory'?",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidateConfig_FromList(t *testing.T) {
+	files := []configFile{{Path: "blade.yaml", Data: []byte(`
+- name: _go
+  run: go run .
+- name: api
+  from: [_go, _db]
+- name: a
+  from: [b]
+  run: ./a
+- name: b
+  from: a
+`)}}
+	var got []string
+	for _, e := range checkConfig(files) {
+		got = append(got, e.String())
+	}
+	want := []string{
+		"blade.yaml:5: from: unknown service '_db', did you mean '_go'?",
+		"blade.yaml:10: from: inheritance cycle: a -> b -> a",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidateConfig_Valid(t *testing.T) {
+	files := []configFile{{Path: "blade.yaml", Data: []byte(`
+- name: _unused-template
+  abstract: true
+  inheritEnv: true
+- name: api
+  run: ./api
+  watch:
+    fs:
+      paths: [.]
+  output:
+    stdout: os
+`)}}
+	if errs := checkConfig(files); len(errs) > 0 {
+		t.Fatalf("expected no problems, got %v", errs)
+	}
+}
+
+func TestSchema_MatchesService(t *testing.T) {
+	type object struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	var doc struct {
+		OneOf       []object          `json:"oneOf"`
+		Definitions map[string]object `json:"definitions"`
+	}
+	if err := json.Unmarshal(schema, &doc); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	var fields, props []string
+	for name := range yamlFields(serviceType) {
+		fields = append(fields, name)
+	}
+	for name := range doc.Definitions["service"].Properties {
+		props = append(props, name)
+	}
+	sort.Strings(fields)
+	sort.Strings(props)
+	if !reflect.DeepEqual(fields, props) {
+		t.Fatalf("schema properties out of sync with service.S:\nfields: %v\nschema: %v", fields, props)
+	}
+
+	var top []string
+	for name := range doc.OneOf[1].Properties {
+		top = append(top, name)
+	}
+	sort.Strings(top)
+	if want := slices.Sorted(slices.Values(topLevelKeys)); !reflect.DeepEqual(top, want) {
+		t.Fatalf("schema top-level keys out of sync:\nkeys: %v\nschema: %v", want, top)
+	}
+}